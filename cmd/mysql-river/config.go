@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/juju/errors"
+	"github.com/obgnail/mysql-river/handler/elasticsearch"
+	"github.com/obgnail/mysql-river/handler/kafka"
+	"github.com/obgnail/mysql-river/handler/trace_log"
+	"github.com/obgnail/mysql-river/river"
+)
+
+const (
+	HandlerTypeTraceLog      = "trace_log"
+	HandlerTypeElasticSearch = "elasticsearch"
+	HandlerTypeKafka         = "kafka"
+)
+
+// Config 对应 toml 配置文件, 参见 river.example.toml
+type Config struct {
+	From          river.From                 `toml:"from"`
+	MySQL         *river.MySQLConfig         `toml:"mysql"`
+	PosAutoSaver  *river.PosAutoSaverConfig  `toml:"pos_auto_saver"`
+	HealthChecker *river.HealthCheckerConfig `toml:"health_checker"`
+	ErrorPolicy   *river.ErrorPolicyConfig   `toml:"error_policy"`
+	Handlers      []*HandlerConfig           `toml:"handler"`
+}
+
+type HandlerConfig struct {
+	Type          string                         `toml:"type"`   // trace_log、elasticsearch、kafka
+	Tables        []string                       `toml:"tables"` // 只处理匹配的表, 如 "orders.*", 为空时处理所有表
+	TraceLog      *trace_log.Config              `toml:"trace_log"`
+	ElasticSearch *elasticsearch.EsHandlerConfig `toml:"elasticsearch"`
+	Kafka         *kafka.Config                  `toml:"kafka"`
+}
+
+func LoadConfig(file string) (*Config, error) {
+	var c Config
+	meta, err := toml.DecodeFile(file, &c)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if undecoded := meta.Undecoded(); len(undecoded) != 0 {
+		return nil, fmt.Errorf("unknown config keys: %v", undecoded)
+	}
+	if err := c.check(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &c, nil
+}
+
+func (c *Config) check() error {
+	if c.MySQL == nil {
+		return fmt.Errorf("missing [mysql] section")
+	}
+	if c.PosAutoSaver == nil {
+		return fmt.Errorf("missing [pos_auto_saver] section")
+	}
+	if err := c.MySQL.Check(); err != nil {
+		return errors.Trace(err)
+	}
+	if c.HealthChecker == nil {
+		c.HealthChecker = &river.HealthCheckerConfig{}
+	}
+	if len(c.From) == 0 {
+		c.From = river.FromFile
+	}
+	for _, hc := range c.Handlers {
+		for _, table := range hc.Tables {
+			if err := river.CheckRoutePattern(table); err != nil {
+				return errors.Annotatef(err, "handler %s", hc.Type)
+			}
+		}
+	}
+	return checkFrom(c.From)
+}
+
+func checkFrom(from river.From) error {
+	if from != river.FromFile && from != river.FromDB {
+		return fmt.Errorf("unknown from: %s", from)
+	}
+	return nil
+}
+
+func (c *Config) RiverConfig() *river.Config {
+	return &river.Config{
+		MySQLConfig:         c.MySQL,
+		PosAutoSaverConfig:  c.PosAutoSaver,
+		HealthCheckerConfig: c.HealthChecker,
+		ErrorPolicyConfig:   c.ErrorPolicy,
+	}
+}
+
+// NewHandler 根据配置创建handler, 配置了多个handler时通过 river.Router 共用一个binlog流
+func (c *Config) NewHandler() (river.Handler, error) {
+	if len(c.Handlers) == 0 {
+		return nil, fmt.Errorf("missing [[handler]] section")
+	}
+	router := river.NewRouter()
+	for _, hc := range c.Handlers {
+		h, err := hc.newHandler()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if len(c.Handlers) == 1 && len(hc.Tables) == 0 {
+			return h, nil
+		}
+		router.Route(h, hc.Tables...)
+	}
+	return router, nil
+}
+
+func (hc *HandlerConfig) newHandler() (river.Handler, error) {
+	switch hc.Type {
+	case HandlerTypeTraceLog:
+		if hc.TraceLog == nil {
+			hc.TraceLog = &trace_log.Config{}
+		}
+		h, err := trace_log.New(hc.TraceLog)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return h, nil
+	case HandlerTypeElasticSearch:
+		if hc.ElasticSearch == nil {
+			return nil, fmt.Errorf("missing [handler.elasticsearch] section")
+		}
+		for _, rule := range hc.ElasticSearch.Rules {
+			if len(rule.Index) == 0 {
+				rule.Index = rule.Table
+			}
+		}
+		return elasticsearch.New(hc.ElasticSearch), nil
+	case HandlerTypeKafka:
+		if hc.Kafka == nil {
+			return nil, fmt.Errorf("missing [handler.kafka] section")
+		}
+		broker, err := kafka.New(hc.Kafka)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return broker, nil
+	default:
+		return nil, fmt.Errorf("unknown handler type: %q", hc.Type)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"github.com/obgnail/mysql-river/handler/trace_log"
+	"github.com/obgnail/mysql-river/river"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	config, err := LoadConfig("river.example.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.From != river.FromFile {
+		t.Errorf("from: %s", config.From)
+	}
+	if config.MySQL.Port != 3306 || config.PosAutoSaver.SaveInterval != 3*time.Second {
+		t.Errorf("river config: %+v %+v", config.MySQL, config.PosAutoSaver)
+	}
+	if config.ErrorPolicy.Action != river.ErrorActionDeadLetter {
+		t.Errorf("error policy: %+v", config.ErrorPolicy)
+	}
+	if len(config.Handlers) != 3 {
+		t.Fatalf("handlers: %d", len(config.Handlers))
+	}
+	if dbs := config.Handlers[0].TraceLog.DBs; len(dbs) != 1 || dbs[0] != "testdb01" {
+		t.Errorf("trace_log: %+v", config.Handlers[0].TraceLog)
+	}
+	if rules := config.Handlers[1].ElasticSearch.Rules; len(rules) != 1 || rules[0].Table != "user" {
+		t.Errorf("elasticsearch: %+v", config.Handlers[1].ElasticSearch)
+	}
+	if config.Handlers[2].Kafka.Topic != "binlog" {
+		t.Errorf("kafka: %+v", config.Handlers[2].Kafka)
+	}
+}
+
+func TestConfig_NewHandler(t *testing.T) {
+	for _, hc := range []*HandlerConfig{
+		{Type: HandlerTypeTraceLog, TraceLog: &trace_log.Config{Format: "xml"}},
+		{Type: HandlerTypeTraceLog, TraceLog: &trace_log.Config{Flashback: &trace_log.FlashbackConfig{StopPos: "bad"}}},
+	} {
+		if _, err := (&Config{Handlers: []*HandlerConfig{hc}}).NewHandler(); err == nil {
+			t.Errorf("expect error for %+v", hc.TraceLog)
+		}
+	}
+}
+
+func TestConfig_CheckTables(t *testing.T) {
+	c := &Config{
+		MySQL:        &river.MySQLConfig{},
+		PosAutoSaver: &river.PosAutoSaverConfig{},
+		Handlers:     []*HandlerConfig{{Type: HandlerTypeTraceLog, Tables: []string{"orders.*", "orders.["}}},
+	}
+	if err := c.check(); err == nil {
+		t.Error("expect error for invalid tables pattern")
+	}
+	c.Handlers[0].Tables = []string{"orders.*"}
+	if err := c.check(); err != nil {
+		t.Error(err)
+	}
+}
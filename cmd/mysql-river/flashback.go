@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"github.com/juju/errors"
+	"github.com/obgnail/mysql-river/handler/trace_log"
+	"github.com/obgnail/mysql-river/river"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+const timeLayout = "2006-01-02 15:04:05"
+
+func runFlashback(args []string) error {
+	fs, configFile := newFlagSet("flashback")
+	startPos := fs.String("start-pos", "", "start position, format: file:pos (required)")
+	stopPos := fs.String("stop-pos", "", "stop position, format: file:pos")
+	startTime := fs.String("start-time", "", "start time, format: "+timeLayout)
+	stopTime := fs.String("stop-time", "", "stop time, format: "+timeLayout)
+	dbs := fs.String("dbs", "", "only flashback these databases, separated by comma")
+	highlight := fs.Bool("highlight", false, "sql highlight")
+	_ = fs.Parse(args)
+
+	config, err := LoadConfig(*configFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	pos, err := river.ParsePosition(*startPos)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	flashback := &trace_log.FlashbackConfig{StartPos: *startPos, StopPos: *stopPos}
+	if len(*stopPos) != 0 {
+		if _, err := river.ParsePosition(*stopPos); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if flashback.StartTime, err = parseTime(*startTime); err != nil {
+		return errors.Trace(err)
+	}
+	if flashback.StopTime, err = parseTime(*stopTime); err != nil {
+		return errors.Trace(err)
+	}
+	traceConfig := &trace_log.Config{Highlight: *highlight, Flashback: flashback}
+	if len(*dbs) != 0 {
+		traceConfig.DBs = strings.Split(*dbs, ",")
+	}
+
+	handler, err := trace_log.New(traceConfig)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	// 闪回不能覆盖正在同步的 master.info
+	saveDir, err := ioutil.TempDir("", "mysql-river-flashback")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer os.RemoveAll(saveDir)
+	riverConfig := &river.Config{
+		MySQLConfig:         config.MySQL,
+		PosAutoSaverConfig:  &river.PosAutoSaverConfig{SaveDir: saveDir},
+		HealthCheckerConfig: config.HealthChecker,
+	}
+
+	r := river.New(riverConfig).SetHandler(handler)
+	go closeOnSignal(r)
+	return errors.Trace(r.SyncFrom(pos))
+}
+
+func parseTime(s string) (time.Time, error) {
+	if len(s) == 0 {
+		return time.Time{}, nil
+	}
+	t, err := time.ParseInLocation(timeLayout, s, time.Local)
+	if err != nil {
+		return t, fmt.Errorf("invalid time %q, expect format: %s", s, timeLayout)
+	}
+	return t, nil
+}
@@ -0,0 +1,69 @@
+// mysql-river 根据 toml 配置文件运行 river, 不需要编写 Go 代码.
+//
+//	mysql-river sync -config river.toml
+//	mysql-river position show -config river.toml
+//	mysql-river flashback -config river.toml -start-pos mysql-bin.000001:4 -stop-pos mysql-bin.000001:1024
+//	mysql-river search -config river.toml -table testdb01.user -pk 1 -start-pos mysql-bin.000001:4
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/obgnail/mysql-river/river"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []*command{
+	{name: "sync", usage: "sync binlog to the configured handlers", run: runSync},
+	{name: "position", usage: "show, set or rewind the saved position, show the db position and lag", run: runPosition},
+	{name: "flashback", usage: "print undo sql of the row changes in a position or time range", run: runFlashback},
+	{name: "search", usage: "find who changed a row and when, from mysql or binlog files", run: runSearch},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: mysql-river <command> [flags]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun 'mysql-river <command> -h' for command flags.\n")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	for _, cmd := range commands {
+		if cmd.name == os.Args[1] {
+			if err := cmd.run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	usage()
+	os.Exit(2)
+}
+
+func newFlagSet(name string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	configFile := fs.String("config", "river.toml", "path of the toml config file")
+	return fs, configFile
+}
+
+// closeOnSignal 收到退出信号时正常关闭river, Sync 等待关闭完成后返回
+func closeOnSignal(r *river.River) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+	r.Close(nil)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/juju/errors"
+	"github.com/obgnail/mysql-river/river"
+	"os"
+)
+
+const positionUsage = `Usage: mysql-river position <subcommand> [flags]
+
+Subcommands:
+  show   show the saved position in master.info
+  db     show the current binlog position of mysql
+  set    set or rewind the saved position, river must be stopped
+         -pos mysql-bin.000001:4 | -gtid 3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5
+  lag    show how many binlog bytes the saved position is behind mysql
+`
+
+func runPosition(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, positionUsage)
+		os.Exit(2)
+	}
+	fs, configFile := newFlagSet("position " + args[0])
+	pos := fs.String("pos", "", "position to save, format: file:pos")
+	gtid := fs.String("gtid", "", "GTID set to start from")
+	_ = fs.Parse(args[1:])
+
+	config, err := LoadConfig(*configFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	switch args[0] {
+	case "show":
+		return showFilePosition(config)
+	case "db":
+		return showDBPosition(config)
+	case "set":
+		return setFilePosition(config, *pos, *gtid)
+	case "lag":
+		return showLag(config)
+	default:
+		fmt.Fprint(os.Stderr, positionUsage)
+		os.Exit(2)
+	}
+	return nil
+}
+
+func showFilePosition(config *Config) error {
+	pos, gtid, err := river.ReadFilePosition(config.PosAutoSaver)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	fmt.Printf("file-position: %s\n", formatPosition(pos))
+	if len(gtid) != 0 {
+		fmt.Printf("gtid:          %s\n", gtid)
+	}
+	return nil
+}
+
+func showDBPosition(config *Config) error {
+	pos, gtid, err := river.ReadDBPosition(config.MySQL)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	fmt.Printf("db-position: %s\n", formatPosition(pos))
+	if len(gtid) != 0 {
+		fmt.Printf("gtid:        %s\n", gtid)
+	}
+	return nil
+}
+
+func setFilePosition(config *Config, pos, gtid string) error {
+	if (len(pos) == 0) == (len(gtid) == 0) {
+		return fmt.Errorf("exactly one of -pos and -gtid is required")
+	}
+	var newPos mysql.Position
+	if len(pos) != 0 {
+		p, err := river.ParsePosition(pos)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		newPos = p
+	} else {
+		if _, err := config.MySQL.ParseGTIDSet(gtid); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	if err := showFilePosition(config); err != nil {
+		return errors.Trace(err)
+	}
+	if err := river.WriteFilePosition(config.PosAutoSaver, newPos, gtid); err != nil {
+		return errors.Trace(err)
+	}
+	fmt.Println("=>")
+	return showFilePosition(config)
+}
+
+func showLag(config *Config) error {
+	pos, gtid, err := river.ReadFilePosition(config.PosAutoSaver)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(gtid) != 0 {
+		return fmt.Errorf("position is set to gtid %s, lag is unknown until river saves a file-position", gtid)
+	}
+	if len(pos.Name) == 0 {
+		return fmt.Errorf("no file-position saved yet")
+	}
+	lag, dbPos, err := river.BinlogLag(config.MySQL, pos)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	fmt.Printf("file-position: %s\ndb-position:   %s\nlag:           %d bytes\n",
+		formatPosition(pos), formatPosition(dbPos), lag)
+	return nil
+}
+
+func formatPosition(pos mysql.Position) string {
+	return fmt.Sprintf("%s:%d", pos.Name, pos.Pos)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"github.com/juju/errors"
+	"github.com/obgnail/mysql-river/handler/trace_log"
+	"github.com/obgnail/mysql-river/river"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// stringsFlag 可以重复指定的flag
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+func runSearch(args []string) error {
+	fs, configFile := newFlagSet("search")
+	table := fs.String("table", "", "table to search, format: db.table (required)")
+	pk := fs.String("pk", "", "primary key values, separated by comma for composite primary key")
+	var where stringsFlag
+	fs.Var(&where, "where", "column=value, can be repeated")
+	startPos := fs.String("start-pos", "", "start position, format: file:pos (required when reading from mysql)")
+	stopPos := fs.String("stop-pos", "", "stop position, format: file:pos")
+	startTime := fs.String("start-time", "", "start time, format: "+timeLayout)
+	stopTime := fs.String("stop-time", "", "stop time, format: "+timeLayout)
+	format := fs.String("format", trace_log.FormatBinlog, "output format: binlog, json or sql")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: mysql-river search -table db.table [-pk 1 | -where col=value] [flags] [binlog files...]\n\n"+
+			"Read from the binlog files if given, otherwise read from mysql.\n\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	files := fs.Args()
+
+	if len(*table) == 0 || !strings.Contains(*table, ".") {
+		return fmt.Errorf("-table db.table is required")
+	}
+	if len(*pk) == 0 && len(where) == 0 {
+		return fmt.Errorf("-pk or -where is required")
+	}
+	search := &trace_log.SearchConfig{StartPos: *startPos, StopPos: *stopPos, Where: where}
+	if len(*pk) != 0 {
+		search.PrimaryKey = strings.Split(*pk, ",")
+	}
+	var err error
+	if search.StartTime, err = parseTime(*startTime); err != nil {
+		return errors.Trace(err)
+	}
+	if search.StopTime, err = parseTime(*stopTime); err != nil {
+		return errors.Trace(err)
+	}
+	traceConfig := &trace_log.Config{
+		Tables: []string{*table},
+		Format: *format,
+		Search: search,
+	}
+	handler, err := trace_log.New(traceConfig)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if len(files) != 0 {
+		return errors.Trace(searchFiles(*configFile, handler, files))
+	}
+	return errors.Trace(searchMySQL(*configFile, handler, *startPos))
+}
+
+// searchFiles 配置文件存在时从mysql中查询binlog没有记录字段名的表结构
+func searchFiles(configFile string, handler river.Handler, files []string) error {
+	var mysqlConfig *river.MySQLConfig
+	if _, err := os.Stat(configFile); err == nil {
+		config, err := LoadConfig(configFile)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		mysqlConfig = config.MySQL
+	}
+	reader := river.NewBinlogFileReader(mysqlConfig, handler)
+	defer reader.Close()
+	return errors.Trace(reader.Read(files...))
+}
+
+func searchMySQL(configFile string, handler river.Handler, startPos string) error {
+	if len(startPos) == 0 {
+		return fmt.Errorf("-start-pos is required when reading from mysql")
+	}
+	pos, err := river.ParsePosition(startPos)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	config, err := LoadConfig(configFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	// 搜索不能覆盖正在同步的 master.info
+	saveDir, err := ioutil.TempDir("", "mysql-river-search")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer os.RemoveAll(saveDir)
+	riverConfig := &river.Config{
+		MySQLConfig:         config.MySQL,
+		PosAutoSaverConfig:  &river.PosAutoSaverConfig{SaveDir: saveDir},
+		HealthCheckerConfig: config.HealthChecker,
+	}
+
+	r := river.New(riverConfig).SetHandler(handler)
+	go closeOnSignal(r)
+	return errors.Trace(r.SyncFrom(pos))
+}
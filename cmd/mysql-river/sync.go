@@ -0,0 +1,31 @@
+package main
+
+import (
+	"github.com/juju/errors"
+	"github.com/obgnail/mysql-river/river"
+)
+
+func runSync(args []string) error {
+	fs, configFile := newFlagSet("sync")
+	from := fs.String("from", "", "where to start: file-position or db-position, override the config file")
+	_ = fs.Parse(args)
+
+	config, err := LoadConfig(*configFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(*from) != 0 {
+		config.From = river.From(*from)
+		if err := checkFrom(config.From); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	handler, err := config.NewHandler()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	r := river.New(config.RiverConfig()).SetHandler(handler)
+	go closeOnSignal(r)
+	return errors.Trace(r.Sync(config.From))
+}
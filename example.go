@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/Shopify/sarama"
 	"github.com/obgnail/mysql-river/handler/elasticsearch"
@@ -43,11 +44,16 @@ func Kafka() {
 	}
 	handler, err := kafka.New(kafkaConfig)
 	PanicIfError(err)
-	go handler.Consume(func(msg *sarama.ConsumerMessage) error {
-		fmt.Printf("Partition:%d, Offset:%d, key:%s, value:%s\n",
-			msg.Partition, msg.Offset, string(msg.Key), string(msg.Value))
-		return nil
-	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel() // river 退出后停止消费
+	go func() {
+		err := handler.Consume(ctx, func(msg *sarama.ConsumerMessage) error {
+			fmt.Printf("Partition:%d, Offset:%d, key:%s, value:%s\n",
+				msg.Partition, msg.Offset, string(msg.Key), string(msg.Value))
+			return nil
+		})
+		PanicIfError(err)
+	}()
 	err = handler.Pipe(river.New(config), river.FromFile)
 	PanicIfError(err)
 }
@@ -59,8 +65,9 @@ func TraceLog() {
 		ShowTxMsg:    true,
 		Highlight:    true,
 	}
-	handler := trace_log.New(traceConfig)
-	err := river.New(config).SetHandler(handler).Sync(river.FromDB) // 从最新位置开始解析
+	handler, err := trace_log.New(traceConfig)
+	PanicIfError(err)
+	err = river.New(config).SetHandler(handler).Sync(river.FromDB) // 从最新位置开始解析
 	PanicIfError(err)
 }
 
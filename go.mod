@@ -9,6 +9,7 @@ require (
 	github.com/go-mysql-org/go-mysql v1.7.0
 	github.com/juju/errors v0.0.0-20210818161939-5560c4c073ff
 	github.com/sirupsen/logrus v1.6.0
+	github.com/xdg-go/scram v1.1.1
 )
 
 require (
@@ -37,6 +38,8 @@ require (
 	github.com/siddontang/go v0.0.0-20180604090527-bdc77568d726 // indirect
 	github.com/siddontang/go-log v0.0.0-20180807004314-8d05993dda07 // indirect
 	github.com/stretchr/testify v1.8.1 // indirect
+	github.com/xdg-go/pbkdf2 v1.0.0 // indirect
+	github.com/xdg-go/stringprep v1.0.3 // indirect
 	go.etcd.io/bbolt v1.3.7 // indirect
 	go.uber.org/atomic v1.7.0 // indirect
 	go.uber.org/multierr v1.6.0 // indirect
@@ -3,12 +3,12 @@ package elasticsearch
 import "time"
 
 type EsHandlerConfig struct {
-	Host          string
-	Port          int64
-	User          string
-	Password      string
-	BulkSize      int
-	FlushInterval time.Duration
-	SkipNoPkTable bool
-	Rules         []*Rule
+	Host          string        `toml:"host"`
+	Port          int64         `toml:"port"`
+	User          string        `toml:"user"`
+	Password      string        `toml:"password"`
+	BulkSize      int           `toml:"bulk_size"`
+	FlushInterval time.Duration `toml:"flush_interval"`
+	SkipNoPkTable bool          `toml:"skip_no_pk_table"`
+	Rules         []*Rule       `toml:"rules"`
 }
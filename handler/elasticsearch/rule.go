@@ -7,32 +7,32 @@ import (
 )
 
 type Rule struct {
-	Schema string `json:"schema"`
-	Table  string `json:"table"`
+	Schema string `json:"schema" toml:"schema"`
+	Table  string `json:"table" toml:"table"`
 
-	Index  string `json:"index"`
-	Parent string `json:"parent"`
+	Index  string `json:"index" toml:"index"`
+	Parent string `json:"parent" toml:"parent"`
 	// If id is none, get primary keys in one row and format them into a string
-	ID string `json:"id"`
+	ID string `json:"id" toml:"id"`
 
 	// Default, a MySQL table field name is mapped to Elasticsearch field name.
 	// Sometimes, you want to use different name, e.g, the MySQL file name is title,
 	// but in Elasticsearch, you want to name it my_title.
-	FieldMapping map[string]string `json:"field_mapping"`
+	FieldMapping map[string]string `json:"field_mapping" toml:"field_mapping"`
 
 	//only MySQL fields in filter will be synced , default sync all fields
-	Filter []string `json:"filter"`
+	Filter []string `json:"filter" toml:"filter"`
 
 	// Elasticsearch pipeline
 	// To pre-process documents before indexing
-	Pipeline string `json:"pipeline"`
+	Pipeline string `json:"pipeline" toml:"pipeline"`
 
-	ActionMapping []*ActionMapping `json:"action_mapping"`
+	ActionMapping []*ActionMapping `json:"action_mapping" toml:"action_mapping"`
 }
 
 type ActionMapping struct {
-	DBAction string `json:"db_action"`
-	ESAction string `json:"es_action"`
+	DBAction string `json:"db_action" toml:"db_action"`
+	ESAction string `json:"es_action" toml:"es_action"`
 }
 
 func NewDefaultRule(schema string, table string) *Rule {
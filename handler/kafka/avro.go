@@ -0,0 +1,166 @@
+package kafka
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/juju/errors"
+	"github.com/obgnail/mysql-river/river"
+	"math"
+)
+
+// AvroHandler 以 Avro 二进制格式生成消息, schema 由表定义生成: namespace 为 {Namespace}.{db}.{table},
+// 消息为 Envelope 记录, 包含 before、after 两个 Row 记录和 op、db、table、log_name、log_pos、gtid、ts;
+// Row 的字段按表定义的顺序排列, 都可以为 null. 只发送行变更, ddl、gtid、xid 等event不发送.
+// 设置了 Registry 时注册schema并使用 Confluent 的 wire format, 否则只包含 Avro 数据
+type AvroHandler struct {
+	DefaultHandler
+	Namespace string
+	Registry  SchemaRegistry
+	Subject   func(event *river.EventData) string // 注册schema的subject, 默认为 Envelope 的全名
+}
+
+var _ BrokerHandler = (*AvroHandler)(nil)
+
+func NewAvroHandler(namespace string, registry SchemaRegistry) *AvroHandler {
+	return &AvroHandler{Namespace: namespace, Registry: registry}
+}
+
+type avroRecord struct {
+	Type      string       `json:"type"`
+	Name      string       `json:"name"`
+	Namespace string       `json:"namespace,omitempty"`
+	Fields    []*avroField `json:"fields"`
+}
+
+type avroField struct {
+	Name    string      `json:"name"`
+	Type    interface{} `json:"type"`
+	Default interface{} `json:"default,omitempty"`
+}
+
+// avroNull 可以为 null 的字段的默认值
+var avroNull = json.RawMessage("null")
+
+func (h *AvroHandler) String() string {
+	return "kafka broker avro handler"
+}
+
+func (h *AvroHandler) Unmarshal([]byte) (*river.EventData, error) {
+	return nil, fmt.Errorf("avro message can not be unmarshalled without schema")
+}
+
+// Schema 返回表对应的 Avro schema
+func (h *AvroHandler) Schema(event *river.EventData) (string, error) {
+	b, err := json.Marshal(h.schema(event, schemaFields(event)))
+	return string(b), errors.Trace(err)
+}
+
+func (h *AvroHandler) schema(event *river.EventData, fields []*schemaField) *avroRecord {
+	row := &avroRecord{Type: "record", Name: "Row"}
+	for _, f := range fields {
+		row.Fields = append(row.Fields, &avroField{Name: schemaName(f.Name), Type: []string{"null", avroType(f.Kind)}, Default: avroNull})
+	}
+	return &avroRecord{
+		Type:      "record",
+		Name:      "Envelope",
+		Namespace: schemaNamespace(h.Namespace, event),
+		Fields: []*avroField{
+			{Name: "before", Type: []interface{}{"null", row}, Default: avroNull},
+			{Name: "after", Type: []string{"null", "Row"}, Default: avroNull},
+			{Name: "op", Type: "string"},
+			{Name: "db", Type: "string"},
+			{Name: "table", Type: "string"},
+			{Name: "log_name", Type: "string"},
+			{Name: "log_pos", Type: "long"},
+			{Name: "gtid", Type: "string"},
+			{Name: "ts", Type: "long"},
+		},
+	}
+}
+
+func avroType(kind fieldKind) string {
+	switch kind {
+	case kindInt:
+		return "int"
+	case kindLong, kindUlong: // bigint unsigned 按补码存为 long
+		return "long"
+	}
+	return kind.String()
+}
+
+func (h *AvroHandler) Marshal(event *river.EventData) ([]byte, error) {
+	if !isRowEvent(event) {
+		return nil, nil
+	}
+	fields := schemaFields(event)
+	before, after := eventRows(event)
+	var b []byte
+	var err error
+	for _, row := range []map[string]interface{}{before, after} {
+		if row == nil {
+			b = appendAvroLong(b, 0)
+			continue
+		}
+		b = appendAvroLong(b, 1)
+		if b, err = appendAvroRow(b, fields, row); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	for _, s := range []string{event.EventType, event.Db, event.Table, event.LogName} {
+		b = appendAvroBytes(b, []byte(s))
+	}
+	b = appendAvroLong(b, int64(event.LogPos))
+	b = appendAvroBytes(b, []byte(event.GTIDSet))
+	b = appendAvroLong(b, int64(event.Timestamp))
+
+	if h.Registry == nil {
+		return b, nil
+	}
+	schema, err := h.Schema(event)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	subject := schemaNamespace(h.Namespace, event) + ".Envelope"
+	if h.Subject != nil {
+		subject = h.Subject(event)
+	}
+	return encodeWithSchema(h.Registry, subject, SchemaTypeAvro, schema, nil, b)
+}
+
+func appendAvroRow(b []byte, fields []*schemaField, row map[string]interface{}) ([]byte, error) {
+	for _, f := range fields {
+		value, err := f.value(row)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if value == nil {
+			b = appendAvroLong(b, 0)
+			continue
+		}
+		b = appendAvroLong(b, 1)
+		switch v := value.(type) {
+		case int64:
+			b = appendAvroLong(b, v)
+		case uint64:
+			b = appendAvroLong(b, int64(v))
+		case float32:
+			b = appendFixed32(b, math.Float32bits(v))
+		case float64:
+			b = appendFixed64(b, math.Float64bits(v))
+		case string:
+			b = appendAvroBytes(b, []byte(v))
+		case []byte:
+			b = appendAvroBytes(b, v)
+		}
+	}
+	return b, nil
+}
+
+// appendAvroLong int 和 long 都使用 zigzag 编码的变长整数
+func appendAvroLong(b []byte, v int64) []byte {
+	return appendUvarint(b, uint64(v<<1)^uint64(v>>63))
+}
+
+func appendAvroBytes(b []byte, v []byte) []byte {
+	return append(appendAvroLong(b, int64(len(v))), v...)
+}
@@ -0,0 +1,128 @@
+package kafka
+
+import (
+	"github.com/Shopify/sarama"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/juju/errors"
+	"github.com/obgnail/mysql-river/river"
+	"sync"
+)
+
+// Message BatchMarshaler 生成的消息. Event 用于决定消息的topic, Key 为nil时使用分区策略根据 Event 生成的key
+type Message struct {
+	Event *river.EventData
+	Key   []byte
+	Value []byte
+}
+
+// BatchMarshaler 按事务批量生成消息. BrokerHandler 实现了该接口时, Broker 缓存事务中的行变更,
+// 直到收到其他event(xid、ddl、gtid等), 再将缓存的行变更和该event一起交给 MarshalBatch.
+// 缓存期间 Broker 确认给river的位置不会超过事务开始前的位置, river 重启后重新发送整个事务
+type BatchMarshaler interface {
+	MarshalBatch(events []*river.EventData) ([]*Message, error)
+}
+
+type batch struct {
+	sync.Mutex
+	events []*river.EventData
+	before mysql.Position // 缓存的第一个行变更之前的位置
+	last   mysql.Position // 最后一个交给 OnEvent 的 event 的位置
+}
+
+func (b *Broker) batchBefore() (mysql.Position, bool) {
+	if b.batch == nil {
+		return mysql.Position{}, false
+	}
+	b.batch.Lock()
+	defer b.batch.Unlock()
+	return b.batch.before, len(b.batch.events) != 0
+}
+
+func (b *Broker) onBatchEvent(m BatchMarshaler, event *river.EventData) error {
+	bt := b.batch
+	bt.Lock()
+	defer bt.Unlock()
+
+	pos := mysql.Position{Name: event.LogName, Pos: event.LogPos}
+	before := bt.last
+	bt.last = pos
+	if buffered(m, event) {
+		if len(bt.events) == 0 {
+			bt.before = before
+		}
+		bt.events = append(bt.events, event)
+		return nil
+	}
+
+	events := append(bt.events[:len(bt.events):len(bt.events)], event)
+	if len(bt.events) != 0 {
+		before = bt.before
+	}
+	// 发送成功后才清空缓存, 发送失败时 ErrorPolicy 重试该event会重新发送整个事务
+	if err := b.sendBatch(m, events, before, pos); err != nil {
+		return errors.Trace(err)
+	}
+	bt.events = nil
+	return nil
+}
+
+// buffered event 是否缓存到事务结束. eventBatch 还缓存 xid、ddl 以外的event, 见 eventBatch
+func buffered(m BatchMarshaler, event *river.EventData) bool {
+	switch event.EventType {
+	case river.EventTypeInsert, river.EventTypeUpdate, river.EventTypeDelete:
+		return true
+	case river.EventTypeXID, river.EventTypeDDL:
+		return false
+	}
+	_, ok := m.(eventBatch)
+	return ok
+}
+
+// Discard 实现 river.Discarder. ErrorPolicy 跳过发送失败的event时丢弃缓存的事务, 否则这些行变更会和下一个事务一起发送
+func (b *Broker) Discard(*river.EventData) []*river.EventData {
+	if b.batch == nil {
+		return nil
+	}
+	b.batch.Lock()
+	defer b.batch.Unlock()
+	events := b.batch.events
+	b.batch.events = nil
+	return events
+}
+
+func (b *Broker) sendBatch(m BatchMarshaler, events []*river.EventData, before, pos mysql.Position) error {
+	msgs, err := m.MarshalBatch(events)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(msgs) == 0 {
+		return errors.Trace(b.producer.send(pos, nil))
+	}
+	if t, ok := b.producer.(*txnProducer); ok {
+		return errors.Trace(b.sendTxn(t, pos, msgs))
+	}
+	// 只有最后一条消息确认后才能确认该事务的位置
+	for i, msg := range msgs {
+		p := before
+		if i == len(msgs)-1 {
+			p = pos
+		}
+		if err := b.send(p, msg); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// sendTxn 在一个kafka事务中发送事务的所有消息和事务提交的位置
+func (b *Broker) sendTxn(t *txnProducer, pos mysql.Position, msgs []*Message) error {
+	producerMsgs := make([]*sarama.ProducerMessage, 0, len(msgs)+1)
+	for _, m := range msgs {
+		msg, err := b.message(m)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		producerMsgs = append(producerMsgs, msg)
+	}
+	return errors.Trace(t.sendTxn(pos, producerMsgs))
+}
@@ -0,0 +1,332 @@
+package kafka
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Shopify/sarama"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/obgnail/mysql-river/river"
+	"reflect"
+	"testing"
+)
+
+// positionProducer 记录每条消息确认的位置
+type positionProducer struct {
+	syncProducer
+	positions []mysql.Position
+	msgs      []*sarama.ProducerMessage
+}
+
+func (p *positionProducer) send(pos mysql.Position, msg *sarama.ProducerMessage) error {
+	if msg != nil {
+		p.positions = append(p.positions, pos)
+		p.msgs = append(p.msgs, msg)
+	}
+	return nil
+}
+
+func txEvents() []*river.EventData {
+	columns := []*river.Column{
+		{Name: "id", Type: river.ColumnTypeNumber, RawType: "int(11)"},
+		{Name: "name", Type: river.ColumnTypeString, RawType: "varchar(255)"},
+		{Name: "status", Type: river.ColumnTypeEnum, RawType: "enum('new','paid')"},
+		{Name: "price", Type: river.ColumnTypeDecimal, RawType: "decimal(10,2)"},
+	}
+	row := func(eventType string, pos uint32, before, after map[string]interface{}) *river.EventData {
+		return &river.EventData{EventType: eventType, ServerID: 1, LogName: "mysql-bin.000002", LogPos: pos,
+			Db: "shop", Table: "order", Primary: []string{"id"}, Columns: columns,
+			Before: before, After: after, Timestamp: 1675603665}
+	}
+	return []*river.EventData{
+		{EventType: river.EventTypeGTID, LogName: "mysql-bin.000002", LogPos: 100},
+		row(river.EventTypeInsert, 200, nil, map[string]interface{}{"id": int32(1), "name": "a", "status": int64(1), "price": "1.50"}),
+		row(river.EventTypeInsert, 300, nil, map[string]interface{}{"id": int32(2), "name": "b", "status": int64(1), "price": "2.00"}),
+		row(river.EventTypeUpdate, 400,
+			map[string]interface{}{"id": int32(1), "name": "a", "status": int64(1), "price": "1.50"},
+			map[string]interface{}{"id": int32(1), "name": "a", "status": int64(2), "price": "1.50"}),
+		{EventType: river.EventTypeXID, LogName: "mysql-bin.000002", LogPos: 500, Timestamp: 1675603665},
+	}
+}
+
+func TestBroker_OnBatchEvent(t *testing.T) {
+	p := &positionProducer{}
+	b := &Broker{config: &Config{Topic: "binlog"}, producer: p, batch: &batch{}, BrokerHandler: NewMaxwellHandler()}
+	events := txEvents()
+	for i, event := range events {
+		if err := b.OnEvent(event); err != nil {
+			t.Fatal(err)
+		}
+		handled := mysql.Position{Name: event.LogName, Pos: event.LogPos}
+		want := handled
+		if i > 0 && i < len(events)-1 {
+			want = mysql.Position{Name: "mysql-bin.000002", Pos: 100} // 事务开始前的位置
+		}
+		if got := b.Committed(handled); got != want {
+			t.Errorf("event %d: committed %s, want %s", i, got, want)
+		}
+	}
+
+	if len(p.msgs) != 3 {
+		t.Fatalf("got %d messages, want 3", len(p.msgs))
+	}
+	before := mysql.Position{Name: "mysql-bin.000002", Pos: 100}
+	wantPositions := []mysql.Position{before, before, {Name: "mysql-bin.000002", Pos: 500}}
+	if !reflect.DeepEqual(p.positions, wantPositions) {
+		t.Errorf("positions: got %v, want %v", p.positions, wantPositions)
+	}
+}
+
+// failProducer 前 fail 次发送失败
+type failProducer struct {
+	positionProducer
+	fail int
+}
+
+func (p *failProducer) send(pos mysql.Position, msg *sarama.ProducerMessage) error {
+	if msg != nil && p.fail > 0 {
+		p.fail--
+		return fmt.Errorf("send failed")
+	}
+	return p.positionProducer.send(pos, msg)
+}
+
+func TestBroker_OnBatchEventRetry(t *testing.T) {
+	p := &failProducer{fail: 1}
+	b := &Broker{config: &Config{Topic: "binlog"}, producer: p, batch: &batch{}, BrokerHandler: NewMaxwellHandler()}
+	events := txEvents()
+	xid := events[len(events)-1]
+	for _, event := range events[:len(events)-1] {
+		if err := b.OnEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.OnEvent(xid); err == nil {
+		t.Fatal("expect error when sending batch")
+	}
+	before := mysql.Position{Name: "mysql-bin.000002", Pos: 100}
+	if got := b.Committed(mysql.Position{Name: xid.LogName, Pos: xid.LogPos}); got != before {
+		t.Errorf("committed %s after failure, want %s", got, before)
+	}
+
+	// ErrorPolicy 重试同一个 xid
+	if err := b.OnEvent(xid); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.msgs) != 3 {
+		t.Fatalf("retried batch: got %d messages, want 3 rows", len(p.msgs))
+	}
+	for i, msg := range p.msgs {
+		var row MaxwellMessage
+		value, _ := msg.Value.Encode()
+		if err := json.Unmarshal(value, &row); err != nil || row.Position != events[i+1].Position() {
+			t.Errorf("message %d: got %s, err %v", i, value, err)
+		}
+	}
+	wantPositions := []mysql.Position{before, before, {Name: "mysql-bin.000002", Pos: 500}}
+	if !reflect.DeepEqual(p.positions, wantPositions) {
+		t.Errorf("positions: got %v, want %v", p.positions, wantPositions)
+	}
+}
+
+func TestBroker_OnBatchEventSkip(t *testing.T) {
+	p := &failProducer{fail: 1}
+	b := &Broker{config: &Config{Topic: "binlog"}, producer: p, batch: &batch{}, BrokerHandler: NewMaxwellHandler()}
+	events := txEvents()
+	xid := events[len(events)-1]
+	for _, event := range events[:len(events)-1] {
+		if err := b.OnEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.OnEvent(xid); err == nil {
+		t.Fatal("expect error when sending batch")
+	}
+	// ErrorPolicy 跳过该 xid, 缓存的行变更被丢弃
+	if discarded := b.Discard(xid); !reflect.DeepEqual(discarded, events[1:len(events)-1]) {
+		t.Errorf("discarded %d events, want 3 rows", len(discarded))
+	}
+
+	// 下一个事务只发送自己的行变更
+	next := txEvents()
+	for _, event := range next {
+		event.LogName = "mysql-bin.000003"
+		if err := b.OnEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(p.msgs) != 3 {
+		t.Fatalf("got %d messages, want 3 rows of the next transaction", len(p.msgs))
+	}
+	for i, msg := range p.msgs {
+		var row MaxwellMessage
+		value, _ := msg.Value.Encode()
+		if err := json.Unmarshal(value, &row); err != nil || row.Position != next[i+1].Position() {
+			t.Errorf("message %d: got %s, err %v", i, value, err)
+		}
+	}
+	handled := mysql.Position{Name: "mysql-bin.000003", Pos: 500}
+	if got := b.Committed(handled); got != handled {
+		t.Errorf("committed %s, want %s", got, handled)
+	}
+}
+
+func TestMaxwellHandler_MarshalBatch(t *testing.T) {
+	events := txEvents()
+	msgs, err := NewMaxwellHandler().MarshalBatch(events[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`{"database":"shop","table":"order","type":"insert","ts":1675603665,"xid":8589935092,"xoffset":0,` +
+			`"position":"mysql-bin.000002:200","server_id":1,"data":{"id":1,"name":"a","price":1.50,"status":"new"}}`,
+		`{"database":"shop","table":"order","type":"insert","ts":1675603665,"xid":8589935092,"xoffset":1,` +
+			`"position":"mysql-bin.000002:300","server_id":1,"data":{"id":2,"name":"b","price":2.00,"status":"new"}}`,
+		`{"database":"shop","table":"order","type":"update","ts":1675603665,"xid":8589935092,"commit":true,` +
+			`"position":"mysql-bin.000002:400","server_id":1,"data":{"id":1,"name":"a","price":1.50,"status":"paid"},"old":{"status":"new"}}`,
+	}
+	if len(msgs) != len(want) {
+		t.Fatalf("got %d messages, want %d", len(msgs), len(want))
+	}
+	for i, msg := range msgs {
+		if string(msg.Value) != want[i] {
+			t.Errorf("message %d:\ngot  %s\nwant %s", i, msg.Value, want[i])
+		}
+	}
+
+	event, err := NewMaxwellHandler().Unmarshal(msgs[2].Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.EventType != river.EventTypeUpdate || event.LogPos != 400 ||
+		event.Before["status"] != "new" || event.After["status"] != "paid" || event.Before["id"] != int64(1) {
+		t.Errorf("unexpected event: %+v", event)
+	}
+
+	ddl := &river.EventData{EventType: river.EventTypeDDL, LogName: "mysql-bin.000002", LogPos: 600,
+		Db: "shop", Table: "order", SQL: "ALTER TABLE `order` ADD COLUMN note text", Timestamp: 1675603665}
+	msgs, err = NewMaxwellHandler().MarshalBatch([]*river.EventData{ddl})
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("ddl: got %d messages, err %v", len(msgs), err)
+	}
+	var msg MaxwellMessage
+	if err := json.Unmarshal(msgs[0].Value, &msg); err != nil || msg.Type != "table-alter" || msg.Ts != 1675603665000 {
+		t.Errorf("unexpected ddl message: %s", msgs[0].Value)
+	}
+}
+
+func TestCanalHandler_MarshalBatch(t *testing.T) {
+	events := txEvents()
+	msgs, err := NewCanalHandler().MarshalBatch(events[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 {
+		t.Fatalf("got %d messages, want 2", len(msgs))
+	}
+
+	var insert, update CanalFlatMessage
+	if err := json.Unmarshal(msgs[0].Value, &insert); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(msgs[1].Value, &update); err != nil {
+		t.Fatal(err)
+	}
+	wantData := []map[string]interface{}{
+		{"id": "1", "name": "a", "status": "new", "price": "1.50"},
+		{"id": "2", "name": "b", "status": "new", "price": "2.00"},
+	}
+	if insert.Type != "INSERT" || insert.Database != "shop" || insert.Table != "order" || insert.IsDdl ||
+		!reflect.DeepEqual(insert.Data, wantData) || insert.Old != nil || insert.Es != 1675603665000 {
+		t.Errorf("unexpected insert message: %s", msgs[0].Value)
+	}
+	wantSQLType := map[string]int{"id": 4, "name": 12, "status": 4, "price": 3}
+	if !reflect.DeepEqual(insert.SQLType, wantSQLType) || insert.MysqlType["price"] != "decimal(10,2)" {
+		t.Errorf("unexpected types: %v %v", insert.SQLType, insert.MysqlType)
+	}
+	wantOld := []map[string]interface{}{{"status": "new"}}
+	if update.Type != "UPDATE" || update.Data[0]["status"] != "paid" || !reflect.DeepEqual(update.Old, wantOld) {
+		t.Errorf("unexpected update message: %s", msgs[1].Value)
+	}
+	if insert.ID != update.ID || insert.ID != transactionID(events[len(events)-1]) {
+		t.Errorf("messages of the same transaction should have the same id")
+	}
+
+	ddl := &river.EventData{EventType: river.EventTypeDDL, Db: "shop", SQL: "DROP TABLE `order`"}
+	msgs, err = NewCanalHandler().MarshalBatch([]*river.EventData{ddl})
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("ddl: got %d messages, err %v", len(msgs), err)
+	}
+	var msg CanalFlatMessage
+	if err := json.Unmarshal(msgs[0].Value, &msg); err != nil || !msg.IsDdl || msg.Type != "ERASE" || msg.SQL != ddl.SQL {
+		t.Errorf("unexpected ddl message: %s", msgs[0].Value)
+	}
+}
+
+func TestCanalHandler_UnmarshalBatch(t *testing.T) {
+	events := txEvents()
+	msgs, err := NewCanalHandler().MarshalBatch(events[1:])
+	if err != nil || len(msgs) != 2 {
+		t.Fatalf("got %d messages, err %v", len(msgs), err)
+	}
+
+	// 通过 ConsumeEvents 解码, 每行变更还原为一个 event
+	handler := &recordHandler{}
+	b := &Broker{BrokerHandler: NewCanalHandler()}
+	for _, msg := range msgs {
+		if err := b.eventConsumer(handler)(&sarama.ConsumerMessage{Value: msg.Value}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(handler.events) != 3 {
+		t.Fatalf("got %d events, want 3", len(handler.events))
+	}
+	insert, update := handler.events[1], handler.events[2]
+	wantInsert := map[string]interface{}{"id": int64(2), "name": "b", "status": "new", "price": "2.00"}
+	if insert.EventType != river.EventTypeInsert || insert.Db != "shop" || insert.Table != "order" ||
+		!reflect.DeepEqual(insert.After, wantInsert) || insert.Timestamp != 1675603665 {
+		t.Errorf("unexpected insert event: %+v", insert)
+	}
+	if update.EventType != river.EventTypeUpdate || update.Before["status"] != "new" || update.After["status"] != "paid" ||
+		update.Before["id"] != int64(1) || !reflect.DeepEqual(update.Primary, []string{"id"}) {
+		t.Errorf("unexpected update event: %+v", update)
+	}
+
+	ddl := &river.EventData{EventType: river.EventTypeDDL, Db: "shop", SQL: "DROP TABLE `order`"}
+	msgs, err = NewCanalHandler().MarshalBatch([]*river.EventData{ddl})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := NewCanalHandler().UnmarshalBatch(msgs[0].Value)
+	if err != nil || len(got) != 1 || got[0].EventType != river.EventTypeDDL || got[0].SQL != ddl.SQL {
+		t.Errorf("unexpected ddl events: %+v, err %v", got, err)
+	}
+
+	if got := canalParseValue(-3, string([]rune{0xff, 0x00})); !reflect.DeepEqual(got, []byte{0xff, 0x00}) {
+		t.Errorf("binary value: got %v", got)
+	}
+	if got := canalParseValue(3, "18446744073709551615"); got != "18446744073709551615" {
+		t.Errorf("decimal value: got %v", got)
+	}
+	if got := canalParseValue(-5, "18446744073709551615"); got != uint64(18446744073709551615) {
+		t.Errorf("bigint value: got %v", got)
+	}
+}
+
+func TestConfig_BrokerHandler(t *testing.T) {
+	for format, want := range map[string]BrokerHandler{
+		"":             &DefaultHandler{},
+		FormatDebezium: NewDebeziumHandler(defaultServerName),
+		FormatCanal:    NewCanalHandler(),
+		FormatMaxwell:  NewMaxwellHandler(),
+		FormatAvro:     NewAvroHandler(defaultServerName, nil),
+		FormatProtobuf: NewProtobufHandler(defaultServerName, nil),
+	} {
+		got, err := (&Config{Format: format}).brokerHandler()
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("format %q: got %v, err %v", format, got, err)
+		}
+	}
+	if _, err := (&Config{Format: "unknown"}).brokerHandler(); err == nil {
+		t.Error("expect error for unknown format")
+	}
+}
@@ -0,0 +1,375 @@
+package kafka
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/juju/errors"
+	"github.com/obgnail/mysql-river/river"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CanalHandler 以 Alibaba Canal 的 flat message 格式生成消息. 事务中同一个表连续的同类行变更合并为一条消息,
+// 所有字段值都转换为字符串; ddl 的 isDdl 为 true. gtid、xid 等event不发送
+type CanalHandler struct {
+	DefaultHandler
+}
+
+var (
+	_ BrokerHandler    = (*CanalHandler)(nil)
+	_ BatchMarshaler   = (*CanalHandler)(nil)
+	_ BatchUnmarshaler = (*CanalHandler)(nil)
+)
+
+func NewCanalHandler() *CanalHandler {
+	return &CanalHandler{}
+}
+
+type CanalFlatMessage struct {
+	ID        int64                    `json:"id"`
+	Database  string                   `json:"database"`
+	Table     string                   `json:"table"`
+	PkNames   []string                 `json:"pkNames"`
+	IsDdl     bool                     `json:"isDdl"`
+	Type      string                   `json:"type"`
+	Es        int64                    `json:"es"` // binlog中的时间, 毫秒
+	Ts        int64                    `json:"ts"` // 生成消息的时间, 毫秒
+	SQL       string                   `json:"sql"`
+	SQLType   map[string]int           `json:"sqlType"`   // java.sql.Types
+	MysqlType map[string]string        `json:"mysqlType"` // 建表语句中的类型
+	Data      []map[string]interface{} `json:"data"`
+	Old       []map[string]interface{} `json:"old"` // update 变更前的值, 只包含变更的字段
+	Gtid      string                   `json:"gtid"`
+}
+
+func (h *CanalHandler) String() string {
+	return "kafka broker canal handler"
+}
+
+// Marshal 单独生成一个event的消息, Broker 使用 MarshalBatch
+func (h *CanalHandler) Marshal(event *river.EventData) ([]byte, error) {
+	msgs, err := h.MarshalBatch([]*river.EventData{event})
+	if err != nil || len(msgs) == 0 {
+		return nil, errors.Trace(err)
+	}
+	return msgs[0].Value, nil
+}
+
+// UnmarshalBatch 将 flat message 还原为其中每一行的 EventData. 字段值按 sqlType 还原: 整数为 int64(超出范围时为 uint64),
+// 浮点数为 float64, 二进制数据为 []byte, 其他为字符串. flat message 中没有binlog位置, LogName 和 LogPos 为空
+func (h *CanalHandler) UnmarshalBatch(b []byte) ([]*river.EventData, error) {
+	msg := &CanalFlatMessage{}
+	if err := json.Unmarshal(b, msg); err != nil {
+		return nil, errors.Trace(err)
+	}
+	newEvent := func(eventType string) *river.EventData {
+		return &river.EventData{
+			EventType: eventType,
+			Db:        msg.Database,
+			Table:     msg.Table,
+			GTIDSet:   msg.Gtid,
+			Primary:   msg.PkNames,
+			Before:    map[string]interface{}{},
+			After:     map[string]interface{}{},
+			Timestamp: uint32(msg.Es / 1000),
+		}
+	}
+	if msg.IsDdl {
+		event := newEvent(river.EventTypeDDL)
+		event.SQL = msg.SQL
+		return []*river.EventData{event}, nil
+	}
+
+	eventType := strings.ToLower(msg.Type)
+	switch eventType {
+	case river.EventTypeInsert, river.EventTypeUpdate, river.EventTypeDelete:
+	default:
+		return nil, fmt.Errorf("unknown canal type: %s", msg.Type)
+	}
+	if eventType == river.EventTypeUpdate && len(msg.Old) != len(msg.Data) {
+		return nil, fmt.Errorf("canal update message has %d rows but %d old rows", len(msg.Data), len(msg.Old))
+	}
+	events := make([]*river.EventData, 0, len(msg.Data))
+	for i, data := range msg.Data {
+		event := newEvent(eventType)
+		row := msg.row(data)
+		switch eventType {
+		case river.EventTypeInsert:
+			event.After = row
+		case river.EventTypeDelete:
+			event.Before = row
+		case river.EventTypeUpdate:
+			event.After = row
+			for field, value := range row {
+				event.Before[field] = value
+			}
+			for field, value := range msg.row(msg.Old[i]) {
+				event.Before[field] = value
+			}
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func (m *CanalFlatMessage) row(data map[string]interface{}) map[string]interface{} {
+	row := make(map[string]interface{}, len(data))
+	for field, value := range data {
+		s, ok := value.(string)
+		if !ok {
+			row[field] = value
+			continue
+		}
+		row[field] = canalParseValue(m.SQLType[field], s)
+	}
+	return row
+}
+
+// canalParseValue 按 sqlType 还原 canalValue 转换的字符串, 无法还原时(如 enum)返回字符串
+func canalParseValue(sqlType int, s string) interface{} {
+	switch sqlType {
+	case -6, 5, 4, -5: // TINYINT、SMALLINT、INTEGER、BIGINT
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i
+		}
+		if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+			return u
+		}
+	case 7, 8: // REAL、DOUBLE
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	case -2, -3, 2004: // BINARY、VARBINARY、BLOB, 按 ISO-8859-1 转换
+		b := make([]byte, 0, len(s))
+		for _, r := range s {
+			if r > 0xff {
+				return s
+			}
+			b = append(b, byte(r))
+		}
+		return b
+	}
+	return s
+}
+
+func (h *CanalHandler) MarshalBatch(events []*river.EventData) ([]*Message, error) {
+	if len(events) == 0 {
+		return nil, nil
+	}
+	id := transactionID(events[len(events)-1])
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+
+	var msgs []*Message
+	var first *river.EventData
+	var flat *CanalFlatMessage
+	flush := func() error {
+		if flat == nil {
+			return nil
+		}
+		b, err := json.Marshal(flat)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		msgs = append(msgs, &Message{Event: first, Value: b})
+		flat = nil
+		return nil
+	}
+
+	for _, event := range events {
+		switch event.EventType {
+		case river.EventTypeInsert, river.EventTypeUpdate, river.EventTypeDelete:
+			typ := strings.ToUpper(event.EventType)
+			if flat == nil || flat.IsDdl || flat.Database != event.Db || flat.Table != event.Table || flat.Type != typ {
+				if err := flush(); err != nil {
+					return nil, errors.Trace(err)
+				}
+				first = event
+				flat = newCanalFlatMessage(id, now, event, typ)
+			}
+			flat.addRow(event)
+		case river.EventTypeDDL:
+			if err := flush(); err != nil {
+				return nil, errors.Trace(err)
+			}
+			first = event
+			flat = &CanalFlatMessage{
+				ID:       id,
+				Database: event.Db,
+				Table:    event.Table,
+				IsDdl:    true,
+				Type:     canalDDLType(event.SQL),
+				Es:       int64(event.Timestamp) * 1000,
+				Ts:       now,
+				SQL:      event.SQL,
+				Gtid:     event.GTIDSet,
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return msgs, nil
+}
+
+func newCanalFlatMessage(id, now int64, event *river.EventData, typ string) *CanalFlatMessage {
+	flat := &CanalFlatMessage{
+		ID:        id,
+		Database:  event.Db,
+		Table:     event.Table,
+		PkNames:   event.Primary,
+		Type:      typ,
+		Es:        int64(event.Timestamp) * 1000,
+		Ts:        now,
+		SQLType:   make(map[string]int, len(event.Columns)),
+		MysqlType: make(map[string]string, len(event.Columns)),
+		Gtid:      event.GTIDSet,
+	}
+	for _, c := range event.Columns {
+		flat.SQLType[c.Name] = canalSQLType(c)
+		flat.MysqlType[c.Name] = c.RawType
+	}
+	return flat
+}
+
+func (m *CanalFlatMessage) addRow(event *river.EventData) {
+	row := event.After
+	if event.EventType == river.EventTypeDelete {
+		row = event.Before
+	}
+	data := canalRow(event, row)
+	m.Data = append(m.Data, data)
+	if event.EventType != river.EventTypeUpdate {
+		return
+	}
+	old := make(map[string]interface{})
+	for field, value := range canalRow(event, event.Before) {
+		if after, ok := data[field]; !ok || !canalValueEqual(after, value) {
+			old[field] = value
+		}
+	}
+	m.Old = append(m.Old, old)
+}
+
+func canalRow(event *river.EventData, row map[string]interface{}) map[string]interface{} {
+	res := make(map[string]interface{}, len(row))
+	for field, value := range row {
+		res[field] = canalValue(event.Column(field), value)
+	}
+	return res
+}
+
+func canalValueEqual(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.(string) == b.(string)
+}
+
+// canalValue 将字段值转换为字符串, 二进制数据按 ISO-8859-1 转换. NULL 为nil
+func canalValue(c *river.Column, value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	if c != nil {
+		if i, ok := toInt64(value); ok {
+			switch c.Type {
+			case river.ColumnTypeEnum:
+				return enumValue(c.RawType, i)
+			case river.ColumnTypeSet:
+				return strings.Join(setValues(c.RawType, i), ",")
+			}
+		}
+	}
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		if c == nil || !c.IsBinary() {
+			return string(v)
+		}
+		runes := make([]rune, len(v))
+		for i, b := range v {
+			runes[i] = rune(b)
+		}
+		return string(runes)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// canalSQLType 返回字段对应的 java.sql.Types, 与 Canal 相同
+func canalSQLType(c *river.Column) int {
+	raw := strings.ToLower(c.RawType)
+	if i := strings.IndexAny(raw, "( "); i >= 0 {
+		raw = raw[:i]
+	}
+	switch raw {
+	case "bit", "set":
+		return -7 // BIT
+	case "tinyint":
+		if c.Unsigned {
+			return 5 // SMALLINT
+		}
+		return -6 // TINYINT
+	case "smallint":
+		if c.Unsigned {
+			return 4 // INTEGER
+		}
+		return 5
+	case "mediumint", "enum":
+		return 4
+	case "int", "integer":
+		if c.Unsigned {
+			return -5 // BIGINT
+		}
+		return 4
+	case "bigint":
+		if c.Unsigned {
+			return 3 // DECIMAL
+		}
+		return -5
+	case "float":
+		return 7 // REAL
+	case "double":
+		return 8 // DOUBLE
+	case "decimal", "numeric":
+		return 3
+	case "date":
+		return 91 // DATE
+	case "time":
+		return 92 // TIME
+	case "datetime", "timestamp":
+		return 93 // TIMESTAMP
+	case "char":
+		return 1 // CHAR
+	case "binary":
+		return -2 // BINARY
+	case "varbinary", "geometry", "point":
+		return -3 // VARBINARY
+	case "tinyblob", "blob", "mediumblob", "longblob":
+		return 2004 // BLOB
+	case "tinytext", "text", "mediumtext", "longtext":
+		return 2005 // CLOB
+	}
+	return 12 // VARCHAR: varchar、year、json 等
+}
+
+// canalDDLType 返回 Canal 中ddl的类型
+func canalDDLType(sql string) string {
+	action, object := ddlStatement(sql)
+	switch {
+	case object == "index" && action == "create":
+		return "CINDEX"
+	case object == "index" && action == "drop":
+		return "DINDEX"
+	case action == "drop":
+		return "ERASE"
+	case action == "":
+		return "QUERY"
+	}
+	return strings.ToUpper(action)
+}
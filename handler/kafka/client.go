@@ -1,19 +1,95 @@
 package kafka
 
 import (
+	"context"
 	"fmt"
 	"github.com/Shopify/sarama"
 	"github.com/juju/errors"
 	"sync"
 )
 
-func NewProducer(addrs []string) (sarama.SyncProducer, error) {
+// NewSaramaConfig 根据 Config 生成生产者和消费者共用的 sarama 配置
+func NewSaramaConfig(config *Config) (*sarama.Config, error) {
 	cfg := sarama.NewConfig()
 	cfg.Producer.RequiredAcks = sarama.WaitForAll
-	cfg.Producer.Partitioner = sarama.NewRandomPartitioner
+	cfg.Producer.Partitioner = config.saramaPartitioner()
 	cfg.Producer.Return.Errors = true
 	cfg.Producer.Return.Successes = true
+	cfg.Producer.Flush.Messages = config.BatchSize
+	cfg.Producer.Flush.Bytes = config.BatchBytes
+	cfg.Producer.Flush.Frequency = config.Linger
+	cfg.Consumer.Offsets.Initial = sarama.OffsetNewest
+	if config.UseOldestOffset {
+		cfg.Consumer.Offsets.Initial = sarama.OffsetOldest
+	}
+	if len(config.Compression) != 0 {
+		if err := cfg.Producer.Compression.UnmarshalText([]byte(config.Compression)); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if err := applyNetConfig(cfg, config); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if config.Transactional {
+		cfg.Producer.Idempotent = true
+		cfg.Producer.Transaction.ID = config.transactionalID()
+		cfg.Net.MaxOpenRequests = 1
+	}
+	if config.Transactional || config.ReadCommitted {
+		cfg.Consumer.IsolationLevel = sarama.ReadCommitted
+	}
+	if cfg.Producer.Compression == sarama.CompressionZSTD && !cfg.Version.IsAtLeast(sarama.V2_1_0_0) {
+		return nil, fmt.Errorf("zstd compression requires kafka version 2.1.0 or later, got %s", cfg.Version)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return cfg, nil
+}
+
+// applyNetConfig 设置连接、认证和重试相关的配置, 零值保持 sarama 的默认值
+func applyNetConfig(cfg *sarama.Config, config *Config) error {
+	if len(config.ClientID) != 0 {
+		cfg.ClientID = config.ClientID
+	}
+	if len(config.Version) != 0 {
+		version, err := sarama.ParseKafkaVersion(config.Version)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		cfg.Version = version
+	}
+	if config.TLS != nil {
+		tlsConfig, err := config.TLS.Build()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		cfg.Net.TLS.Enable = true
+		cfg.Net.TLS.Config = tlsConfig
+	}
+	if config.SASL != nil {
+		if err := config.SASL.apply(cfg); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if config.MaxMessageBytes > 0 {
+		cfg.Producer.MaxMessageBytes = config.MaxMessageBytes
+	}
+	if config.MaxRetries < 0 {
+		cfg.Producer.Retry.Max = 0
+	} else if config.MaxRetries > 0 {
+		cfg.Producer.Retry.Max = config.MaxRetries
+	}
+	if config.RetryBackoff > 0 {
+		cfg.Producer.Retry.Backoff = config.RetryBackoff
+		cfg.Consumer.Retry.Backoff = config.RetryBackoff
+		cfg.Metadata.Retry.Backoff = config.RetryBackoff
+		cfg.Admin.Retry.Backoff = config.RetryBackoff
+	}
+	return nil
+}
 
+func NewProducer(addrs []string, cfg *sarama.Config) (sarama.SyncProducer, error) {
 	client, err := sarama.NewSyncProducer(addrs, cfg)
 	if err != nil {
 		return nil, errors.Trace(err)
@@ -21,11 +97,15 @@ func NewProducer(addrs []string) (sarama.SyncProducer, error) {
 	return client, nil
 }
 
-func SendMessage(producer sarama.SyncProducer, topic string, content []byte) (partition int32, offset int64, err error) {
+// SendMessage key为nil时随机分区, 否则key相同的消息发送到同一个分区(需要使用 sarama.NewHashPartitioner)
+func SendMessage(producer sarama.SyncProducer, topic string, key []byte, content []byte) (partition int32, offset int64, err error) {
 	msg := &sarama.ProducerMessage{
 		Topic: topic,
 		Value: sarama.ByteEncoder(content),
 	}
+	if key != nil {
+		msg.Key = sarama.ByteEncoder(key)
+	}
 	partition, offset, err = producer.SendMessage(msg)
 	if err != nil {
 		err = errors.Trace(err)
@@ -42,43 +122,108 @@ func OldestOffsetGetter(int32) (int64, error) {
 	return sarama.OffsetOldest, nil
 }
 
-func Consume(addrs []string, topic string,
+// Consume 为topic的每个分区开一个goroutine消费, 直到ctx结束或 consumeFunc 返回error.
+// ctx结束时返回nil, 否则返回第一个error
+func Consume(ctx context.Context, addrs []string, cfg *sarama.Config, topic string,
 	getOffsetFunc func(partition int32) (int64, error),
 	consumeFunc func(msg *sarama.ConsumerMessage) error) error {
 	if getOffsetFunc == nil {
 		getOffsetFunc = NewestOffsetGetter
 	}
 	if consumeFunc == nil {
-		return fmt.Errorf("has no getOffsetFunc")
+		return fmt.Errorf("has no consumeFunc")
 	}
 
-	consumer, err := sarama.NewConsumer(addrs, nil)
+	consumer, err := sarama.NewConsumer(addrs, cfg)
 	if err != nil {
 		return errors.Trace(err)
 	}
-	partitionList, err := consumer.Partitions(topic)
+	defer consumer.Close()
+	return consumePartitions(ctx, consumer, topic, getOffsetFunc, consumeFunc)
+}
+
+func consumePartitions(ctx context.Context, consumer sarama.Consumer, topic string,
+	getOffsetFunc func(partition int32) (int64, error),
+	consumeFunc func(msg *sarama.ConsumerMessage) error) error {
+	partitions, err := consumer.Partitions(topic)
 	if err != nil {
 		return errors.Trace(err)
 	}
-	var wg sync.WaitGroup
-	for partition := range partitionList {
-		offset, err := getOffsetFunc(int32(partition))
+
+	c := newPartitionConsumers(ctx)
+	for _, partition := range partitions {
+		offset, err := getOffsetFunc(partition)
 		if err != nil {
-			return fmt.Errorf("get offsetStore error: %s", err.Error())
+			c.stop(fmt.Errorf("get offsetStore error: %s", err.Error()))
+			break
 		}
-		pc, err := consumer.ConsumePartition(topic, int32(partition), offset)
+		pc, err := consumer.ConsumePartition(topic, partition, offset)
 		if err != nil {
-			return errors.Trace(err)
+			c.stop(errors.Trace(err))
+			break
 		}
-		wg.Add(1)
-		go func(pc sarama.PartitionConsumer) { // 为每个分区开一个go协程去取值
-			defer pc.AsyncClose()
-			for msg := range pc.Messages() { // 阻塞直到有值发送过来，然后再继续等待
-				err = consumeFunc(msg)
-				errors.ErrorStack(errors.Trace(err))
+		c.consume(pc, consumeFunc)
+	}
+	return c.wait()
+}
+
+// partitionConsumers 管理每个分区的消费goroutine, 任意分区出错时停止所有分区
+type partitionConsumers struct {
+	parent context.Context
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	sync.Mutex
+	err error
+}
+
+func newPartitionConsumers(parent context.Context) *partitionConsumers {
+	ctx, cancel := context.WithCancel(parent)
+	return &partitionConsumers{parent: parent, ctx: ctx, cancel: cancel}
+}
+
+func (c *partitionConsumers) consume(pc sarama.PartitionConsumer, consumeFunc func(msg *sarama.ConsumerMessage) error) {
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer pc.AsyncClose()
+		for {
+			select {
+			case <-c.ctx.Done():
+				return
+			case msg, ok := <-pc.Messages():
+				if !ok {
+					return
+				}
+				if err := consumeFunc(msg); err != nil {
+					c.stop(errors.Annotatef(err, "consume topic %s partition %d offset %d", msg.Topic, msg.Partition, msg.Offset))
+					return
+				}
 			}
-		}(pc)
+		}
+	}()
+}
+
+// stop 记录第一个error并停止所有分区. ctx结束后 consumeFunc 返回的error不再记录
+func (c *partitionConsumers) stop(err error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.err == nil && c.parent.Err() == nil {
+		c.err = err
 	}
-	wg.Wait()
-	return nil
+	c.cancel()
+}
+
+// wait 等待所有分区停止消费
+func (c *partitionConsumers) wait() error {
+	c.wg.Wait()
+	c.cancel()
+	return c.error()
+}
+
+func (c *partitionConsumers) error() error {
+	c.Lock()
+	defer c.Unlock()
+	return c.err
 }
@@ -0,0 +1,92 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/obgnail/mysql-river/river"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConsumePartitions(t *testing.T) {
+	consumer := mocks.NewConsumer(t, nil)
+	consumer.SetTopicMetadata(map[string][]int32{"binlog": {3, 7}})
+	consumer.ExpectConsumePartition("binlog", 3, 10).
+		YieldMessage(&sarama.ConsumerMessage{Value: []byte("a")}).
+		YieldMessage(&sarama.ConsumerMessage{Value: []byte("fail")}).
+		YieldMessage(&sarama.ConsumerMessage{Value: []byte("b")})
+	consumer.ExpectConsumePartition("binlog", 7, 20)
+
+	offsets := map[int32]int64{3: 10, 7: 20}
+	getOffset := func(partition int32) (int64, error) {
+		offset, ok := offsets[partition]
+		if !ok {
+			return 0, fmt.Errorf("unexpected partition %d", partition)
+		}
+		return offset, nil
+	}
+
+	var lock sync.Mutex
+	var consumed []string
+	err := consumePartitions(context.Background(), consumer, "binlog", getOffset, func(msg *sarama.ConsumerMessage) error {
+		if string(msg.Value) == "fail" {
+			return fmt.Errorf("consume error")
+		}
+		lock.Lock()
+		consumed = append(consumed, string(msg.Value))
+		lock.Unlock()
+		return nil
+	})
+	if err == nil {
+		t.Error("expect consume error")
+	}
+	if len(consumed) != 1 || consumed[0] != "a" {
+		t.Errorf("consumption should stop at the failed message, got %v", consumed)
+	}
+}
+
+func TestConsumePartitions_Cancel(t *testing.T) {
+	consumer := mocks.NewConsumer(t, nil)
+	consumer.SetTopicMetadata(map[string][]int32{"binlog": {0}})
+	consumer.ExpectConsumePartition("binlog", 0, sarama.OffsetNewest)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- consumePartitions(ctx, consumer, "binlog", NewestOffsetGetter, func(*sarama.ConsumerMessage) error { return nil })
+	}()
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("cancel should stop consuming without error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("consumer does not stop after cancel")
+	}
+}
+
+func TestBroker_WithErrorPolicy(t *testing.T) {
+	calls := 0
+	f := func(*sarama.ConsumerMessage) error {
+		calls++
+		return fmt.Errorf("consume error")
+	}
+	policy := &river.ErrorPolicyConfig{MaxRetries: 2, RetryInterval: time.Millisecond, Action: river.ErrorActionSkip}
+	b := &Broker{config: &Config{ConsumeErrorPolicy: policy}}
+	if err := b.withErrorPolicy(context.Background(), f)(&sarama.ConsumerMessage{}); err != nil || calls != 3 {
+		t.Errorf("skip after retries: got err %v, calls %d", err, calls)
+	}
+
+	calls = 0
+	policy.Action = river.ErrorActionStop
+	if err := b.withErrorPolicy(context.Background(), f)(&sarama.ConsumerMessage{}); err == nil || calls != 3 {
+		t.Errorf("stop after retries: got err %v, calls %d", err, calls)
+	}
+	if err := checkConsumeErrorPolicy(&river.ErrorPolicyConfig{Action: river.ErrorActionDeadLetter}); err == nil {
+		t.Error("dead letter is not supported when consuming")
+	}
+}
@@ -0,0 +1,77 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+	"github.com/obgnail/mysql-river/river"
+)
+
+// Unmarshaler 将消息还原为 EventData, 与 BrokerHandler.Marshal 对应.
+// BrokerHandler 实现了 Unmarshaler 时 ConsumeEvents 使用它解码, 否则使用 river.Bytes2Event
+type Unmarshaler interface {
+	Unmarshal(b []byte) (*river.EventData, error)
+}
+
+// BatchUnmarshaler 将一条消息还原为多个 EventData, 与 BatchMarshaler 对应(如 Canal 的 flat message 包含多行变更).
+// BrokerHandler 实现了 BatchUnmarshaler 时 ConsumeEvents 优先使用它解码
+type BatchUnmarshaler interface {
+	UnmarshalBatch(b []byte) ([]*river.EventData, error)
+}
+
+func (h *DefaultHandler) Unmarshal(b []byte) (*river.EventData, error) {
+	return river.Bytes2Event(b)
+}
+
+// ConsumeEvents 消费kafka中的数据, 解码后交给handler, 使kafka中的数据可以直接同步到 elasticsearch、trace_log 等handler.
+// 设置了 TopicTemplate 时消费 ControlTopic. 消费结束后调用 handler.OnClose, river.Error 为消费失败的error
+func (b *Broker) ConsumeEvents(ctx context.Context, handler river.Handler) error {
+	return b.consumeEvents(ctx, b.config.controlTopic(), handler)
+}
+
+// ConsumeTableEvents 消费某个表的数据并交给handler, 需要设置 TopicTemplate
+func (b *Broker) ConsumeTableEvents(ctx context.Context, db, table string, handler river.Handler) error {
+	if len(b.config.TopicTemplate) == 0 {
+		return fmt.Errorf("topic template is empty")
+	}
+	return b.consumeEvents(ctx, TopicName(b.config.TopicTemplate, db, table), handler)
+}
+
+func (b *Broker) consumeEvents(ctx context.Context, topic string, handler river.Handler) error {
+	err := b.consumeTopic(ctx, topic, b.eventConsumer(handler))
+	handler.OnClose(&river.River{Error: err})
+	return errors.Trace(err)
+}
+
+func (b *Broker) eventConsumer(handler river.Handler) func(msg *sarama.ConsumerMessage) error {
+	return func(msg *sarama.ConsumerMessage) error {
+		events, err := b.unmarshal(msg.Value)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		for _, event := range events {
+			if err := handler.OnEvent(event); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		return nil
+	}
+}
+
+func (b *Broker) unmarshal(value []byte) ([]*river.EventData, error) {
+	if u, ok := b.BrokerHandler.(BatchUnmarshaler); ok {
+		return u.UnmarshalBatch(value)
+	}
+	var event *river.EventData
+	var err error
+	if u, ok := b.BrokerHandler.(Unmarshaler); ok {
+		event, err = u.Unmarshal(value)
+	} else {
+		event, err = river.Bytes2Event(value)
+	}
+	if err != nil || event == nil {
+		return nil, errors.Trace(err)
+	}
+	return []*river.EventData{event}, nil
+}
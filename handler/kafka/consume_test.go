@@ -0,0 +1,55 @@
+package kafka
+
+import (
+	"github.com/Shopify/sarama"
+	"github.com/obgnail/mysql-river/river"
+	"testing"
+)
+
+type recordHandler struct {
+	river.NopCloserAlerter
+	events []*river.EventData
+}
+
+func (h *recordHandler) String() string { return "record" }
+
+func (h *recordHandler) OnEvent(event *river.EventData) error {
+	h.events = append(h.events, event)
+	return nil
+}
+
+// skipHandler 不解码任何消息
+type skipHandler struct {
+	DefaultHandler
+}
+
+func (h *skipHandler) Unmarshal([]byte) (*river.EventData, error) {
+	return nil, nil
+}
+
+func TestBroker_EventConsumer(t *testing.T) {
+	event := &river.EventData{EventType: river.EventTypeInsert, Db: "db", Table: "user",
+		Columns: []*river.Column{{Name: "id", Type: river.ColumnTypeNumber}}, After: map[string]interface{}{"id": 1}}
+	value, err := river.Event2Bytes(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := &recordHandler{}
+	b := &Broker{BrokerHandler: &DefaultHandler{}}
+	if err := b.eventConsumer(handler)(&sarama.ConsumerMessage{Value: value}); err != nil {
+		t.Fatal(err)
+	}
+	if len(handler.events) != 1 || handler.events[0].After["id"] != int64(1) {
+		t.Errorf("unexpected events: %+v", handler.events)
+	}
+	if err := b.eventConsumer(handler)(&sarama.ConsumerMessage{Value: []byte("{")}); err == nil {
+		t.Error("expect error for invalid message")
+	}
+
+	handler = &recordHandler{}
+	b.SetHandler(&skipHandler{})
+	if err := b.eventConsumer(handler)(&sarama.ConsumerMessage{Value: value}); err != nil || len(handler.events) != 0 {
+		t.Errorf("custom unmarshaler should be used, got %v %+v", err, handler.events)
+	}
+}
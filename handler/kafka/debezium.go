@@ -0,0 +1,458 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/juju/errors"
+	"github.com/obgnail/mysql-river/river"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DebeziumOpCreate = "c"
+	DebeziumOpUpdate = "u"
+	DebeziumOpDelete = "d"
+	DebeziumOpRead   = "r" // 快照读取, 解码时视为 insert
+
+	debeziumVersion   = "mysql-river"
+	debeziumConnector = "mysql"
+)
+
+// DebeziumHandler 以 Debezium MySQL connector 的格式生成消息: value 为 before/after/source/op/ts_ms 组成的 envelope,
+// key 为主键字段, 没有主键的表key为nil. 只发送行变更, ddl、gtid、xid 等event不发送.
+// 字段值按 Debezium 的默认配置转换: decimal 为字符串, datetime 为毫秒时间戳, timestamp 为 UTC 的 ISO-8601 字符串,
+// date 为距 1970-01-01 的天数, time 为微秒数, enum、set 为字符串, 二进制数据为 base64 字符串
+type DebeziumHandler struct {
+	DefaultHandler
+	ServerName    string // 对应 Debezium 的 database.server.name, 用于 source.name 和 schema 的名字
+	IncludeSchema bool   // 对应 JsonConverter 的 schemas.enable, 消息为 {"schema": ..., "payload": ...}
+}
+
+var (
+	_ BrokerHandler    = (*DebeziumHandler)(nil)
+	_ MessageMarshaler = (*DebeziumHandler)(nil)
+	_ Unmarshaler      = (*DebeziumHandler)(nil)
+)
+
+func NewDebeziumHandler(serverName string) *DebeziumHandler {
+	return &DebeziumHandler{ServerName: serverName}
+}
+
+type DebeziumSource struct {
+	Version   string  `json:"version"`
+	Connector string  `json:"connector"`
+	Name      string  `json:"name"`
+	TsMs      int64   `json:"ts_ms"`
+	Snapshot  string  `json:"snapshot"`
+	Db        string  `json:"db"`
+	Table     string  `json:"table"`
+	ServerID  uint32  `json:"server_id"`
+	GTID      *string `json:"gtid"`
+	File      string  `json:"file"`
+	Pos       uint32  `json:"pos"`
+	Row       int     `json:"row"`
+	Thread    *int64  `json:"thread"`
+	Query     *string `json:"query"`
+}
+
+type DebeziumPayload struct {
+	Before map[string]interface{} `json:"before"`
+	After  map[string]interface{} `json:"after"`
+	Source *DebeziumSource        `json:"source"`
+	Op     string                 `json:"op"`
+	TsMs   int64                  `json:"ts_ms"`
+}
+
+// DebeziumSchema Kafka Connect 的 schema
+type DebeziumSchema struct {
+	Type     string            `json:"type"`
+	Optional bool              `json:"optional"`
+	Name     string            `json:"name,omitempty"`
+	Field    string            `json:"field,omitempty"`
+	Fields   []*DebeziumSchema `json:"fields,omitempty"`
+}
+
+type debeziumMessage struct {
+	Schema  *DebeziumSchema `json:"schema"`
+	Payload interface{}     `json:"payload"`
+}
+
+func (h *DebeziumHandler) String() string {
+	return "kafka broker debezium handler"
+}
+
+func (h *DebeziumHandler) Marshal(event *river.EventData) ([]byte, error) {
+	_, value, err := h.MarshalMessage(event)
+	return value, err
+}
+
+func (h *DebeziumHandler) MarshalMessage(event *river.EventData) (key, value []byte, err error) {
+	var op string
+	switch event.EventType {
+	case river.EventTypeInsert:
+		op = DebeziumOpCreate
+	case river.EventTypeUpdate:
+		op = DebeziumOpUpdate
+	case river.EventTypeDelete:
+		op = DebeziumOpDelete
+	default:
+		return nil, nil, nil
+	}
+
+	payload := &DebeziumPayload{
+		Source: h.source(event),
+		Op:     op,
+		TsMs:   time.Now().UnixNano() / int64(time.Millisecond),
+	}
+	if op != DebeziumOpCreate {
+		payload.Before = debeziumRow(event, event.Before)
+	}
+	if op != DebeziumOpDelete {
+		payload.After = debeziumRow(event, event.After)
+	}
+	if value, err = h.encode(h.valueSchema(event), payload); err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+
+	if len(event.Primary) == 0 {
+		return nil, value, nil
+	}
+	row := payload.After
+	if op == DebeziumOpDelete {
+		row = payload.Before
+	}
+	pk := make(map[string]interface{}, len(event.Primary))
+	for _, field := range event.Primary {
+		pk[field] = row[field]
+	}
+	if key, err = h.encode(h.keySchema(event), pk); err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	return key, value, nil
+}
+
+func (h *DebeziumHandler) encode(schema *DebeziumSchema, payload interface{}) ([]byte, error) {
+	if h.IncludeSchema {
+		return json.Marshal(&debeziumMessage{Schema: schema, Payload: payload})
+	}
+	return json.Marshal(payload)
+}
+
+func (h *DebeziumHandler) source(event *river.EventData) *DebeziumSource {
+	source := &DebeziumSource{
+		Version:   debeziumVersion,
+		Connector: debeziumConnector,
+		Name:      h.ServerName,
+		TsMs:      int64(event.Timestamp) * 1000,
+		Snapshot:  "false",
+		Db:        event.Db,
+		Table:     event.Table,
+		ServerID:  event.ServerID,
+		File:      event.LogName,
+		Pos:       event.LogPos,
+	}
+	if len(event.GTIDSet) != 0 {
+		gtid := event.GTIDSet
+		source.GTID = &gtid
+	}
+	return source
+}
+
+// Unmarshal 将 Debezium 的消息(包含或不包含schema)还原为 EventData.
+// 消息中没有主键和字段定义, Primary 和 Columns 为空; 整数还原为 int64, 浮点数还原为 float64, 其余字段保持 Debezium 的格式
+func (h *DebeziumHandler) Unmarshal(b []byte) (*river.EventData, error) {
+	var msg map[string]json.RawMessage
+	if err := json.Unmarshal(b, &msg); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if msg == nil { // tombstone
+		return nil, nil
+	}
+	if payload, ok := msg["payload"]; ok {
+		if _, ok := msg["schema"]; ok {
+			b = payload
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	payload := &DebeziumPayload{}
+	if err := decoder.Decode(payload); err != nil {
+		return nil, errors.Trace(err)
+	}
+	event := &river.EventData{Before: normalizeNumbers(payload.Before), After: normalizeNumbers(payload.After)}
+	switch payload.Op {
+	case DebeziumOpCreate, DebeziumOpRead:
+		event.EventType = river.EventTypeInsert
+	case DebeziumOpUpdate:
+		event.EventType = river.EventTypeUpdate
+	case DebeziumOpDelete:
+		event.EventType = river.EventTypeDelete
+	default:
+		return nil, fmt.Errorf("unknown debezium op: %s", payload.Op)
+	}
+	if s := payload.Source; s != nil {
+		event.ServerID = s.ServerID
+		event.LogName = s.File
+		event.LogPos = s.Pos
+		event.Db = s.Db
+		event.Table = s.Table
+		event.Timestamp = uint32(s.TsMs / 1000)
+		if s.GTID != nil {
+			event.GTIDSet = *s.GTID
+		}
+	}
+	return event, nil
+}
+
+func normalizeNumbers(row map[string]interface{}) map[string]interface{} {
+	for field, value := range row {
+		n, ok := value.(json.Number)
+		if !ok {
+			continue
+		}
+		if i, err := n.Int64(); err == nil {
+			row[field] = i
+		} else if f, err := n.Float64(); err == nil {
+			row[field] = f
+		}
+	}
+	return row
+}
+
+func (h *DebeziumHandler) schemaName(event *river.EventData, suffix string) string {
+	return strings.Join([]string{h.ServerName, event.Db, event.Table, suffix}, ".")
+}
+
+func (h *DebeziumHandler) keySchema(event *river.EventData) *DebeziumSchema {
+	schema := &DebeziumSchema{Type: "struct", Name: h.schemaName(event, "Key")}
+	for _, field := range event.Primary {
+		s := debeziumFieldSchema(event.Column(field), field)
+		s.Optional = false
+		schema.Fields = append(schema.Fields, s)
+	}
+	return schema
+}
+
+func (h *DebeziumHandler) valueSchema(event *river.EventData) *DebeziumSchema {
+	row := &DebeziumSchema{Type: "struct", Optional: true, Name: h.schemaName(event, "Value")}
+	for _, c := range event.Columns {
+		s := debeziumFieldSchema(c, c.Name)
+		for _, field := range event.Primary {
+			if field == c.Name {
+				s.Optional = false
+			}
+		}
+		row.Fields = append(row.Fields, s)
+	}
+	before, after := *row, *row
+	before.Field, after.Field = "before", "after"
+
+	field := func(typ, name string, optional bool) *DebeziumSchema {
+		return &DebeziumSchema{Type: typ, Field: name, Optional: optional}
+	}
+	source := &DebeziumSchema{Type: "struct", Name: "io.debezium.connector.mysql.Source", Field: "source", Fields: []*DebeziumSchema{
+		field("string", "version", false),
+		field("string", "connector", false),
+		field("string", "name", false),
+		field("int64", "ts_ms", false),
+		{Type: "string", Optional: true, Name: "io.debezium.data.Enum", Field: "snapshot"},
+		field("string", "db", false),
+		field("string", "table", true),
+		field("int64", "server_id", false),
+		field("string", "gtid", true),
+		field("string", "file", false),
+		field("int64", "pos", false),
+		field("int32", "row", false),
+		field("int64", "thread", true),
+		field("string", "query", true),
+	}}
+	return &DebeziumSchema{Type: "struct", Name: h.schemaName(event, "Envelope"), Fields: []*DebeziumSchema{
+		&before, &after, source,
+		field("string", "op", false),
+		field("int64", "ts_ms", true),
+	}}
+}
+
+func debeziumFieldSchema(column *river.Column, field string) *DebeziumSchema {
+	typ, name := debeziumType(column)
+	return &DebeziumSchema{Type: typ, Optional: true, Name: name, Field: field}
+}
+
+// debeziumType 返回字段在 Debezium 中的类型和语义类型的名字
+func debeziumType(c *river.Column) (typ, name string) {
+	if c == nil {
+		return "string", ""
+	}
+	raw := strings.ToLower(c.RawType)
+	switch c.Type {
+	case river.ColumnTypeNumber, river.ColumnTypeMediumInt:
+		switch {
+		case strings.HasPrefix(raw, "year"):
+			return "int32", "io.debezium.time.Year"
+		case strings.HasPrefix(raw, "tinyint"):
+			return "int16", ""
+		case strings.HasPrefix(raw, "smallint"):
+			if c.Unsigned {
+				return "int32", ""
+			}
+			return "int16", ""
+		case strings.HasPrefix(raw, "bigint"):
+			return "int64", ""
+		case strings.HasPrefix(raw, "int") && c.Unsigned:
+			return "int64", ""
+		}
+		return "int32", ""
+	case river.ColumnTypeFloat:
+		if strings.HasPrefix(raw, "float") {
+			return "float", ""
+		}
+		return "double", ""
+	case river.ColumnTypeEnum:
+		return "string", "io.debezium.data.Enum"
+	case river.ColumnTypeSet:
+		return "string", "io.debezium.data.EnumSet"
+	case river.ColumnTypeDatetime:
+		return "int64", "io.debezium.time.Timestamp"
+	case river.ColumnTypeTimestamp:
+		return "string", "io.debezium.time.ZonedTimestamp"
+	case river.ColumnTypeDate:
+		return "int32", "io.debezium.time.Date"
+	case river.ColumnTypeTime:
+		return "int64", "io.debezium.time.MicroTime"
+	case river.ColumnTypeBit:
+		if raw == "bit(1)" {
+			return "boolean", ""
+		}
+		return "bytes", "io.debezium.data.Bits"
+	case river.ColumnTypeJSON:
+		return "string", "io.debezium.data.Json"
+	}
+	if c.IsBinary() {
+		return "bytes", ""
+	}
+	return "string", ""
+}
+
+func debeziumRow(event *river.EventData, row map[string]interface{}) map[string]interface{} {
+	res := make(map[string]interface{}, len(row))
+	for field, value := range row {
+		res[field] = debeziumValue(event.Column(field), value)
+	}
+	return res
+}
+
+// debeziumValue 将字段值转换为 Debezium 的格式, 无法转换的时间(如 0000-00-00)转换为nil
+func debeziumValue(c *river.Column, value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	typ, name := debeziumType(c)
+	switch name {
+	case "io.debezium.data.Enum":
+		if i, ok := toInt64(value); ok {
+			return enumValue(c.RawType, i)
+		}
+	case "io.debezium.data.EnumSet":
+		if i, ok := toInt64(value); ok {
+			return strings.Join(setValues(c.RawType, i), ",")
+		}
+	case "io.debezium.data.Bits":
+		if i, ok := toInt64(value); ok {
+			return bitsValue(c.RawType, i)
+		}
+	case "io.debezium.time.Timestamp":
+		if t, ok := parseTime(value, time.UTC); ok {
+			return t.UnixNano() / int64(time.Millisecond)
+		}
+		return nil
+	case "io.debezium.time.ZonedTimestamp":
+		if t, ok := parseTime(value, time.Local); ok {
+			return t.UTC().Format("2006-01-02T15:04:05.999999Z")
+		}
+		return nil
+	case "io.debezium.time.Date":
+		if t, ok := parseTime(value, time.UTC); ok {
+			return int32(t.Unix() / 86400)
+		}
+		return nil
+	case "io.debezium.time.MicroTime":
+		if d, ok := parseDuration(value); ok {
+			return d.Microseconds()
+		}
+		return nil
+	}
+
+	switch typ {
+	case "boolean":
+		if i, ok := toInt64(value); ok {
+			return i != 0
+		}
+	case "bytes":
+		if s, ok := value.(string); ok {
+			return []byte(s)
+		}
+	case "string":
+		if b, ok := value.([]byte); ok {
+			return string(b)
+		}
+	}
+	return value
+}
+
+func parseTime(value interface{}, loc *time.Location) (time.Time, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, true
+	case string:
+		for _, layout := range []string{"2006-01-02 15:04:05.999999", "2006-01-02"} {
+			if t, err := time.ParseInLocation(layout, v, loc); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseDuration 解析 time 类型的值, 格式为 [-]HH:MM:SS[.ffffff]
+func parseDuration(value interface{}) (time.Duration, bool) {
+	s, ok := value.(string)
+	if !ok {
+		return 0, false
+	}
+	negative := strings.HasPrefix(s, "-")
+	parts := strings.Split(strings.TrimPrefix(s, "-"), ":")
+	if len(parts) != 3 {
+		return 0, false
+	}
+	hour, err1 := strconv.Atoi(parts[0])
+	minute, err2 := strconv.Atoi(parts[1])
+	second, err3 := strconv.ParseFloat(parts[2], 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0, false
+	}
+	d := time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute +
+		time.Duration(second*float64(time.Second)).Round(time.Microsecond)
+	if negative {
+		d = -d
+	}
+	return d, true
+}
+
+// bitsValue 与 Debezium 相同, 以小端序的字节表示 bit(n)
+func bitsValue(rawType string, value int64) []byte {
+	n := 64
+	if start, end := strings.Index(rawType, "("), strings.Index(rawType, ")"); start >= 0 && end > start {
+		if i, err := strconv.Atoi(rawType[start+1 : end]); err == nil {
+			n = i
+		}
+	}
+	b := make([]byte, (n+7)/8)
+	for i := range b {
+		b[i] = byte(value >> (8 * uint(i)))
+	}
+	return b
+}
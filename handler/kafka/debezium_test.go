@@ -0,0 +1,156 @@
+package kafka
+
+import (
+	"encoding/json"
+	"github.com/obgnail/mysql-river/river"
+	"reflect"
+	"testing"
+)
+
+func TestDebeziumHandler_MarshalMessage(t *testing.T) {
+	event := &river.EventData{
+		EventType: river.EventTypeUpdate,
+		ServerID:  1,
+		LogName:   "mysql-bin.000001",
+		LogPos:    1234,
+		Db:        "shop",
+		Table:     "user",
+		Timestamp: 1675603665,
+		Primary:   []string{"id"},
+		Columns: []*river.Column{
+			{Name: "id", Type: river.ColumnTypeNumber, RawType: "int(10) unsigned", Unsigned: true},
+			{Name: "name", Type: river.ColumnTypeString, RawType: "text"},
+			{Name: "price", Type: river.ColumnTypeDecimal, RawType: "decimal(10,2)"},
+			{Name: "status", Type: river.ColumnTypeEnum, RawType: "enum('new','it''s done')"},
+			{Name: "tags", Type: river.ColumnTypeSet, RawType: "set('a','b','c')"},
+			{Name: "created", Type: river.ColumnTypeDatetime, RawType: "datetime"},
+			{Name: "birthday", Type: river.ColumnTypeDate, RawType: "date"},
+			{Name: "duration", Type: river.ColumnTypeTime, RawType: "time"},
+			{Name: "deleted", Type: river.ColumnTypeBit, RawType: "bit(1)"},
+		},
+		Before: map[string]interface{}{"id": uint32(1), "name": []byte("li"), "status": int64(1)},
+		After: map[string]interface{}{
+			"id":       uint32(1),
+			"name":     []byte("lihua"),
+			"price":    "12.30",
+			"status":   int64(2),
+			"tags":     int64(5),
+			"created":  "1970-01-02 00:00:01",
+			"birthday": "1970-01-11",
+			"duration": "-01:00:00.5",
+			"deleted":  int64(1),
+		},
+	}
+	h := NewDebeziumHandler("dbserver1")
+	key, value, err := h.MarshalMessage(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(key) != `{"id":1}` {
+		t.Errorf("key: got %s", key)
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(value, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload["op"] != "u" {
+		t.Errorf("op: got %v", payload["op"])
+	}
+	wantAfter := map[string]interface{}{
+		"id":       1.0,
+		"name":     "lihua",
+		"price":    "12.30",
+		"status":   "it's done",
+		"tags":     "a,c",
+		"created":  86401000.0,
+		"birthday": 10.0,
+		"duration": -3600500000.0,
+		"deleted":  true,
+	}
+	if !reflect.DeepEqual(payload["after"], wantAfter) {
+		t.Errorf("after: got %v, want %v", payload["after"], wantAfter)
+	}
+	wantBefore := map[string]interface{}{"id": 1.0, "name": "li", "status": "new"}
+	if !reflect.DeepEqual(payload["before"], wantBefore) {
+		t.Errorf("before: got %v, want %v", payload["before"], wantBefore)
+	}
+	source := payload["source"].(map[string]interface{})
+	if source["name"] != "dbserver1" || source["db"] != "shop" || source["table"] != "user" ||
+		source["file"] != "mysql-bin.000001" || source["pos"] != 1234.0 || source["ts_ms"] != 1675603665000.0 {
+		t.Errorf("unexpected source: %v", source)
+	}
+
+	// 只发送行变更
+	if key, value, err := h.MarshalMessage(&river.EventData{EventType: river.EventTypeXID}); err != nil || key != nil || value != nil {
+		t.Errorf("xid should not be sent, got %s %s %v", key, value, err)
+	}
+}
+
+func TestDebeziumHandler_Schema(t *testing.T) {
+	event := &river.EventData{
+		EventType: river.EventTypeDelete,
+		Db:        "shop",
+		Table:     "user",
+		Primary:   []string{"id"},
+		Columns:   []*river.Column{{Name: "id", Type: river.ColumnTypeNumber, RawType: "bigint(20)"}},
+		Before:    map[string]interface{}{"id": int64(1)},
+	}
+	h := &DebeziumHandler{ServerName: "dbserver1", IncludeSchema: true}
+	key, value, err := h.MarshalMessage(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"schema":{"type":"struct","optional":false,"name":"dbserver1.shop.user.Key",` +
+		`"fields":[{"type":"int64","optional":false,"field":"id"}]},"payload":{"id":1}}`
+	if string(key) != want {
+		t.Errorf("key: got %s, want %s", key, want)
+	}
+
+	var msg struct {
+		Schema  *DebeziumSchema  `json:"schema"`
+		Payload *DebeziumPayload `json:"payload"`
+	}
+	if err := json.Unmarshal(value, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Schema.Name != "dbserver1.shop.user.Envelope" || msg.Schema.Fields[0].Field != "before" ||
+		msg.Schema.Fields[0].Fields[0].Type != "int64" || msg.Schema.Fields[2].Name != "io.debezium.connector.mysql.Source" {
+		t.Errorf("unexpected schema: %s", value)
+	}
+	if msg.Payload.Op != "d" || msg.Payload.After != nil {
+		t.Errorf("unexpected payload: %s", value)
+	}
+}
+
+func TestDebeziumHandler_Unmarshal(t *testing.T) {
+	h := NewDebeziumHandler("dbserver1")
+	event := &river.EventData{
+		EventType: river.EventTypeInsert,
+		ServerID:  1,
+		LogName:   "mysql-bin.000001",
+		LogPos:    1234,
+		Db:        "shop",
+		Table:     "user",
+		GTIDSet:   "3e11fa47-71ca-11e1-9e33-c80aa9429562:23",
+		Timestamp: 1675603665,
+		After:     map[string]interface{}{"id": int64(1), "score": 9.5, "name": "lihua"},
+	}
+	for _, includeSchema := range []bool{false, true} {
+		h.IncludeSchema = includeSchema
+		value, err := h.Marshal(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := h.Unmarshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, event) {
+			t.Errorf("schema %t: got %+v, want %+v", includeSchema, got, event)
+		}
+	}
+	if got, err := h.Unmarshal([]byte("null")); err != nil || got != nil {
+		t.Errorf("tombstone: got %+v %v", got, err)
+	}
+}
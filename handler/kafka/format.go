@@ -0,0 +1,174 @@
+package kafka
+
+import (
+	"fmt"
+	"github.com/obgnail/mysql-river/river"
+	"strconv"
+	"strings"
+)
+
+const (
+	FormatJSON     = "json"     // river.EventData 的json, 默认
+	FormatDebezium = "debezium" // Debezium 的 envelope, 见 DebeziumHandler
+	FormatCanal    = "canal"    // Alibaba Canal 的 flat message, 见 CanalHandler
+	FormatMaxwell  = "maxwell"  // Maxwell 的json, 见 MaxwellHandler
+	FormatAvro     = "avro"     // Avro, 见 AvroHandler
+	FormatProtobuf = "protobuf" // Protobuf, 见 ProtobufHandler
+
+	defaultServerName = "mysql-river"
+)
+
+// brokerHandler 返回 Format 对应的 BrokerHandler
+func (c *Config) brokerHandler() (BrokerHandler, error) {
+	serverName := c.ServerName
+	if len(serverName) == 0 {
+		serverName = defaultServerName
+	}
+	switch c.Format {
+	case "", FormatJSON:
+		return &DefaultHandler{}, nil
+	case FormatDebezium:
+		return NewDebeziumHandler(serverName), nil
+	case FormatCanal:
+		return NewCanalHandler(), nil
+	case FormatMaxwell:
+		return NewMaxwellHandler(), nil
+	case FormatAvro:
+		h := NewAvroHandler(serverName, c.schemaRegistry())
+		h.Subject = c.schemaSubject()
+		return h, nil
+	case FormatProtobuf:
+		h := NewProtobufHandler(serverName, c.schemaRegistry())
+		h.Subject = c.schemaSubject()
+		return h, nil
+	}
+	return nil, fmt.Errorf("invalid format: %s", c.Format)
+}
+
+func (c *Config) schemaRegistry() SchemaRegistry {
+	if len(c.SchemaRegistry) == 0 {
+		return nil
+	}
+	registry := NewConfluentRegistry(c.SchemaRegistry)
+	registry.Username = c.SchemaRegistryUser
+	registry.Password = c.SchemaRegistryPassword
+	return registry
+}
+
+// schemaSubject 按表发送到不同的topic时使用 Confluent 默认的 TopicNameStrategy: {topic}-value.
+// 所有表发送到同一个topic时不同表的schema不兼容, 返回nil, 使用 RecordNameStrategy: {namespace}.Envelope
+func (c *Config) schemaSubject() func(event *river.EventData) string {
+	if len(c.SchemaRegistry) == 0 || len(c.TopicTemplate) == 0 {
+		return nil
+	}
+	return func(event *river.EventData) string {
+		return c.topicOf(event) + "-value"
+	}
+}
+
+// transactionID 由事务最后一个event的位置生成事务的id: binlog文件的序号<<32 | 位置.
+// river 不知道 mysql 的 xid, 该id在同一个mysql实例中唯一且递增
+func transactionID(event *river.EventData) int64 {
+	seq, _ := strconv.ParseInt(event.LogName[strings.LastIndex(event.LogName, ".")+1:], 10, 32)
+	return seq<<32 | int64(event.LogPos)
+}
+
+// ddlStatement 返回ddl的操作(create、alter、drop、rename、truncate)和对象(database、table、index 等), 无法识别时返回空字符串
+func ddlStatement(sql string) (action, object string) {
+	words := strings.Fields(strings.ToLower(sql))
+	if len(words) == 0 {
+		return "", ""
+	}
+	action = words[0]
+	switch action {
+	case "create", "alter", "drop", "rename", "truncate":
+	default:
+		return "", ""
+	}
+	for _, word := range words[1:] {
+		switch word {
+		case "database", "schema":
+			return action, "database"
+		case "table", "index", "view":
+			return action, word
+		}
+	}
+	if action == "truncate" {
+		return action, "table"
+	}
+	return action, ""
+}
+
+// enumOptions 解析 enum('a','b') 或 set('a','b') 中的选项
+func enumOptions(rawType string) []string {
+	start, end := strings.Index(rawType, "("), strings.LastIndex(rawType, ")")
+	if start < 0 || end <= start {
+		return nil
+	}
+	var options []string
+	var option strings.Builder
+	quoted := false
+	s := rawType[start+1 : end]
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\'' && quoted && i+1 < len(s) && s[i+1] == '\'':
+			option.WriteByte('\'')
+			i++
+		case s[i] == '\'':
+			if quoted {
+				options = append(options, option.String())
+				option.Reset()
+			}
+			quoted = !quoted
+		case quoted:
+			option.WriteByte(s[i])
+		}
+	}
+	return options
+}
+
+// enumValue enum 的值为从1开始的序号, 0 表示空字符串
+func enumValue(rawType string, index int64) string {
+	options := enumOptions(rawType)
+	if index <= 0 || int(index) > len(options) {
+		return ""
+	}
+	return options[index-1]
+}
+
+// setValues set 的值为选项的位图
+func setValues(rawType string, bitmap int64) []string {
+	values := []string{}
+	for i, option := range enumOptions(rawType) {
+		if bitmap&(1<<uint(i)) != 0 {
+			values = append(values, option)
+		}
+	}
+	return values
+}
+
+func toInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint:
+		return int64(v), true
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint64:
+		return int64(v), true
+	}
+	return 0, false
+}
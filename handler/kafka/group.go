@@ -0,0 +1,60 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+	"github.com/obgnail/mysql-river/river"
+)
+
+// ConsumeGroup 以消费者组的方式消费topics, 分区在组内的多个消费者之间自动分配, offset提交到kafka.
+// consumeFunc 成功后才会提交该消息的offset. ctx结束时返回nil, consumeFunc 返回error时停止消费并返回该error
+func ConsumeGroup(ctx context.Context, addrs []string, cfg *sarama.Config, groupID string, topics []string,
+	consumeFunc func(msg *sarama.ConsumerMessage) error) error {
+	if consumeFunc == nil {
+		return fmt.Errorf("has no consumeFunc")
+	}
+	group, err := sarama.NewConsumerGroup(addrs, groupID, cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer group.Close()
+
+	handler := &groupHandler{consumeFunc: consumeFunc, consumers: newPartitionConsumers(ctx)}
+	defer handler.consumers.cancel()
+	for {
+		// 每次rebalance后 Consume 都会返回, 需要重新加入消费者组
+		err := group.Consume(handler.consumers.ctx, topics, handler)
+		if e := handler.consumers.error(); e != nil {
+			return errors.Trace(e)
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+		river.Logger.Infof("kafka consumer group %s rebalanced", groupID)
+	}
+}
+
+// groupHandler 实现了 sarama.ConsumerGroupHandler
+type groupHandler struct {
+	consumeFunc func(msg *sarama.ConsumerMessage) error
+	consumers   *partitionConsumers
+}
+
+func (h *groupHandler) Setup(sarama.ConsumerGroupSession) error   { return nil }
+func (h *groupHandler) Cleanup(sarama.ConsumerGroupSession) error { return nil }
+
+func (h *groupHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for msg := range claim.Messages() {
+		if err := h.consumeFunc(msg); err != nil {
+			h.consumers.stop(errors.Annotatef(err, "consume topic %s partition %d offset %d", msg.Topic, msg.Partition, msg.Offset))
+			return nil
+		}
+		sess.MarkMessage(msg, "")
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"github.com/Shopify/sarama"
+	"testing"
+)
+
+type fakeSession struct {
+	sarama.ConsumerGroupSession
+	marked []int64
+}
+
+func (s *fakeSession) MarkMessage(msg *sarama.ConsumerMessage, _ string) {
+	s.marked = append(s.marked, msg.Offset)
+}
+
+type fakeClaim struct {
+	sarama.ConsumerGroupClaim
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c *fakeClaim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
+
+func TestGroupHandler_ConsumeClaim(t *testing.T) {
+	claim := &fakeClaim{messages: make(chan *sarama.ConsumerMessage, 3)}
+	for i := int64(0); i < 3; i++ {
+		claim.messages <- &sarama.ConsumerMessage{Topic: "binlog", Offset: i}
+	}
+	close(claim.messages)
+
+	handler := &groupHandler{
+		consumeFunc: func(msg *sarama.ConsumerMessage) error {
+			if msg.Offset == 1 {
+				return fmt.Errorf("consume error")
+			}
+			return nil
+		},
+		consumers: newPartitionConsumers(context.Background()),
+	}
+	sess := &fakeSession{}
+	if err := handler.ConsumeClaim(sess, claim); err != nil {
+		t.Fatal(err)
+	}
+	if len(sess.marked) != 1 || sess.marked[0] != 0 {
+		t.Errorf("only offsets before the failed message should be marked, got %v", sess.marked)
+	}
+	if handler.consumers.error() == nil || handler.consumers.ctx.Err() == nil {
+		t.Error("consume error should stop the consumer group")
+	}
+}
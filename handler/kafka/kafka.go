@@ -1,23 +1,78 @@
 package kafka
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 	"github.com/Shopify/sarama"
+	"github.com/go-mysql-org/go-mysql/mysql"
 	"github.com/juju/errors"
 	"github.com/obgnail/mysql-river/river"
 	"os"
 	"path"
+	"time"
 )
 
 var offsetStoreName = "kafka_offset.bolt"
 
 type Config struct {
-	Addrs           []string `json:"addrs"`
-	Topic           string   `json:"topic"`
-	OffsetStoreDir  string   `json:"offset_store_dir"`
-	Offset          *int64   `json:"offsetStore"` // if it has no offset, set nil
-	UseOldestOffset bool     `json:"use_oldest_offset"`
+	Addrs           []string `json:"addrs" toml:"addrs"`
+	Topic           string   `json:"topic" toml:"topic"`
+	OffsetStoreDir  string   `json:"offset_store_dir" toml:"offset_store_dir"`
+	Offset          *int64   `json:"offsetStore" toml:"offset"` // if it has no offset, set nil
+	UseOldestOffset bool     `json:"use_oldest_offset" toml:"use_oldest_offset"`
+
+	// 设置后以消费者组的方式消费, offset提交到kafka, 不再使用 OffsetStoreDir 和 Offset;
+	// 消费者组第一次消费某个分区时从最新(或 UseOldestOffset 时从最早)的消息开始
+	GroupID string `json:"group_id" toml:"group_id"`
+	// 消费失败时的处理策略, 支持 stop(默认) 和 skip; 为nil时不重试, 直接停止消费
+	ConsumeErrorPolicy *river.ErrorPolicyConfig `json:"consume_error_policy" toml:"consume_error_policy"`
+
+	// 按表发送到不同的topic, 例如 binlog.{db}.{table}; ddl、gtid、xid 等event发送到 ControlTopic, 默认为 Topic
+	TopicTemplate string `json:"topic_template" toml:"topic_template"`
+	ControlTopic  string `json:"control_topic" toml:"control_topic"`
+	// 发送消息前自动创建topic, 默认 1 个分区, 1 个副本
+	AutoCreateTopic        bool  `json:"auto_create_topic" toml:"auto_create_topic"`
+	TopicPartitions        int32 `json:"topic_partitions" toml:"topic_partitions"`
+	TopicReplicationFactor int16 `json:"topic_replication_factor" toml:"topic_replication_factor"`
+
+	// 分区策略: random、table、primary 或 custom, 默认 random; 设置了 PartitionKey 时默认 custom
+	Partitioner  string                              `json:"partitioner" toml:"partitioner"`
+	PartitionKey func(event *river.EventData) []byte `json:"-" toml:"-"` // custom 分区策略的消息key
+
+	// 消息格式: json(默认)、debezium、canal、maxwell、avro 或 protobuf, 可以被 SetHandler 覆盖
+	Format     string `json:"format" toml:"format"`
+	ServerName string `json:"server_name" toml:"server_name"` // debezium 格式的 database.server.name, avro、protobuf 的 namespace, 默认 mysql-river
+	// avro、protobuf 格式的 Confluent Schema Registry 地址, 消息使用 Confluent 的 wire format. 设置了 TopicTemplate 时
+	// 按 {topic}-value 注册schema, 否则所有表在同一个topic中, 按 {namespace}.Envelope 注册
+	SchemaRegistry         string `json:"schema_registry" toml:"schema_registry"`
+	SchemaRegistryUser     string `json:"schema_registry_user" toml:"schema_registry_user"`
+	SchemaRegistryPassword string `json:"schema_registry_password" toml:"schema_registry_password"`
+
+	// 异步批量发送, river 只保存kafka确认的位置
+	Async       bool          `json:"async" toml:"async"`
+	BatchSize   int           `json:"batch_size" toml:"batch_size"`       // 达到该消息数时发送, 0 表示不限制
+	BatchBytes  int           `json:"batch_bytes" toml:"batch_bytes"`     // 达到该字节数时发送, 0 表示不限制
+	Linger      time.Duration `json:"linger" toml:"linger"`               // 消息最多等待该时间后发送, 0 表示立即发送
+	Compression string        `json:"compression" toml:"compression"`     // none、gzip、snappy、lz4 或 zstd, 默认 none
+	MaxInFlight int           `json:"max_in_flight" toml:"max_in_flight"` // 未确认的消息数上限, 达到上限时 OnEvent 阻塞, 默认 10000
+
+	// 连接kafka的配置, 生产者、消费者和创建topic共用
+	ClientID        string           `json:"client_id" toml:"client_id"`                 // 默认 sarama
+	Version         string           `json:"version" toml:"version"`                     // kafka的版本, 如 2.8.0, 默认 1.0.0; zstd 压缩需要 2.1.0 以上
+	TLS             *river.TLSConfig `json:"tls" toml:"tls"`                             // 为nil时不使用TLS
+	SASL            *SASLConfig      `json:"sasl" toml:"sasl"`                           // 为nil时不使用SASL
+	MaxMessageBytes int              `json:"max_message_bytes" toml:"max_message_bytes"` // 单条消息的最大字节数, 默认 1000000
+	MaxRetries      int              `json:"max_retries" toml:"max_retries"`             // 发送失败时的重试次数, 默认 3, -1 表示不重试
+	RetryBackoff    time.Duration    `json:"retry_backoff" toml:"retry_backoff"`         // 发送、消费、获取元数据失败时重试的间隔, 默认 100ms(消费为 2s)
+
+	// 精确一次: 使用幂等的事务生产者, 每个mysql事务的消息和事务提交的binlog位置在一个kafka事务中发送,
+	// Pipe 从 PositionTopic 中最后提交的位置开始解析. 不能和 Async 同时使用
+	Transactional   bool   `json:"transactional" toml:"transactional"`
+	TransactionalID string `json:"transactional_id" toml:"transactional_id"` // 同一时间只能有一个river使用, 默认 mysql-river
+	PositionTopic   string `json:"position_topic" toml:"position_topic"`     // 默认 {ControlTopic}.position
+	// 消费者只读取已提交的事务中的消息, 开启 Transactional 时也会开启. 只消费的程序不要开启 Transactional
+	ReadCommitted bool `json:"read_committed" toml:"read_committed"`
 }
 
 func (c *Config) GetOffset() int64 {
@@ -37,6 +92,12 @@ type BrokerHandler interface {
 	OnClose(river *river.River)
 }
 
+// MessageMarshaler 同时生成消息的key和value. BrokerHandler 实现了该接口时使用它代替 Marshal,
+// 返回的key不为nil时代替分区策略生成的key
+type MessageMarshaler interface {
+	MarshalMessage(event *river.EventData) (key, value []byte, err error)
+}
+
 type DefaultHandler struct{}
 
 func (h *DefaultHandler) String() string {
@@ -50,7 +111,9 @@ func (h *DefaultHandler) OnAlert(msg *river.StatusMsg) error {
 	return nil
 }
 func (h *DefaultHandler) OnClose(r *river.River) {
-	river.Logger.Errorf("%+v", r.Error.Error())
+	if r.Error != nil {
+		river.Logger.Errorf("%+v", r.Error.Error())
+	}
 }
 
 // Broker 实现了 river.Handler 中的核心函数 OnEvent, 添加了校验, offset自动存储功能。
@@ -58,41 +121,99 @@ func (h *DefaultHandler) OnClose(r *river.River) {
 // Broker example:
 //		broker, err := New(brokerConfig)
 //		broker.SetHandler(...)
-//		go broker.Consume(func(msg *sarama.ConsumerMessage) error {
+//		go broker.Consume(ctx, func(msg *sarama.ConsumerMessage) error {
 //			// consume your event
 //		})
 //      err := broker.Pipe(river.River, river.FromFile)
 type Broker struct {
-	config      *Config
-	offsetStore *Offset
-	producer    sarama.SyncProducer
+	config       *Config
+	offsetStore  *Offset // 使用消费者组时为nil
+	saramaConfig *sarama.Config
+	producer     producer
+	keyFunc      partitionKeyFunc
+	topics       *topicCreator // 为nil时不自动创建topic
+	batch        *batch        // BrokerHandler 实现了 BatchMarshaler 或使用事务生产者时缓存事务中的行变更
 	BrokerHandler
 }
 
-var _ river.Handler = (*Broker)(nil)
+var (
+	_ river.Handler   = (*Broker)(nil)
+	_ river.Committer = (*Broker)(nil)
+	_ river.Discarder = (*Broker)(nil)
+)
 
 func New(config *Config) (*Broker, error) {
-	if len(config.OffsetStoreDir) == 0 {
-		return nil, fmt.Errorf("offsetStore store dir is empty")
+	if err := config.checkTopic(); err != nil {
+		return nil, errors.Trace(err)
 	}
-	if err := os.MkdirAll(config.OffsetStoreDir, 0755); err != nil {
+	if err := checkConsumeErrorPolicy(config.ConsumeErrorPolicy); err != nil {
 		return nil, errors.Trace(err)
 	}
-	filePath := path.Join(config.OffsetStoreDir, offsetStoreName)
-	offset, err := NewOffset(filePath)
+	if err := config.checkTransactional(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	keyFunc, err := config.partitionKeyFunc()
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
+	// 使用消费者组时offset提交到kafka, 不需要本地存储
+	var offset *Offset
+	if len(config.GroupID) == 0 {
+		if len(config.OffsetStoreDir) == 0 {
+			return nil, fmt.Errorf("offsetStore store dir is empty")
+		}
+		if err := os.MkdirAll(config.OffsetStoreDir, 0755); err != nil {
+			return nil, errors.Trace(err)
+		}
+		filePath := path.Join(config.OffsetStoreDir, offsetStoreName)
+		if offset, err = NewOffset(filePath); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
 
-	producer, err := NewProducer(config.Addrs)
+	brokerHandler, err := config.brokerHandler()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	cfg, err := NewSaramaConfig(config)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var topics *topicCreator
+	if config.AutoCreateTopic {
+		if topics, err = newTopicCreator(config.Addrs, cfg, config); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	if topics != nil && config.Transactional {
+		if err = topics.create(config.positionTopic()); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	var p producer
+	if config.Async {
+		p, err = newAsyncProducer(config.Addrs, cfg, config.MaxInFlight)
+	} else if config.Transactional {
+		var client sarama.SyncProducer
+		client, err = NewProducer(config.Addrs, cfg)
+		p = &txnProducer{producer: client, positionTopic: config.positionTopic(), key: []byte(config.transactionalID())}
+	} else {
+		var client sarama.SyncProducer
+		client, err = NewProducer(config.Addrs, cfg)
+		p = &syncProducer{producer: client}
+	}
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
 	h := &Broker{
 		config:        config,
 		offsetStore:   offset,
-		producer:      producer,
-		BrokerHandler: &DefaultHandler{},
+		saramaConfig:  cfg,
+		producer:      p,
+		keyFunc:       keyFunc,
+		topics:        topics,
+		batch:         &batch{},
+		BrokerHandler: brokerHandler,
 	}
 	return h, nil
 }
@@ -103,44 +224,144 @@ func (b *Broker) SetHandler(handler BrokerHandler) *Broker {
 }
 
 func (b *Broker) OnEvent(event *river.EventData) error {
-	result, err := b.Marshal(event)
+	if m, ok := b.BrokerHandler.(BatchMarshaler); ok {
+		return errors.Trace(b.onBatchEvent(m, event))
+	}
+	if _, ok := b.producer.(*txnProducer); ok {
+		return errors.Trace(b.onBatchEvent(eventBatch{broker: b}, event))
+	}
+	pos := mysql.Position{Name: event.LogName, Pos: event.LogPos}
+	key, result, err := b.marshal(event)
 	if err != nil {
 		return errors.Trace(err)
 	}
 	if len(result) == 0 {
-		return nil
+		return errors.Trace(b.producer.send(pos, nil))
 	}
-	if _, _, err = SendMessage(b.producer, b.config.Topic, result); err != nil {
+	return errors.Trace(b.send(pos, &Message{Event: event, Key: key, Value: result}))
+}
+
+// send 发送消息
+func (b *Broker) send(pos mysql.Position, m *Message) error {
+	msg, err := b.message(m)
+	if err != nil {
 		return errors.Trace(err)
 	}
-	return nil
+	return errors.Trace(b.producer.send(pos, msg))
+}
+
+// message 生成kafka消息, 消息的key为nil时使用分区策略生成的key
+func (b *Broker) message(m *Message) (*sarama.ProducerMessage, error) {
+	topic := b.config.topicOf(m.Event)
+	if b.topics != nil {
+		if err := b.topics.create(topic); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	msg := &sarama.ProducerMessage{Topic: topic, Value: sarama.ByteEncoder(m.Value)}
+	key := m.Key
+	if key == nil && b.keyFunc != nil {
+		key = b.keyFunc(m.Event)
+	}
+	if key != nil {
+		msg.Key = sarama.ByteEncoder(key)
+	}
+	return msg, nil
+}
+
+// marshal 返回消息的key和value, BrokerHandler 没有实现 MessageMarshaler 时key由分区策略生成
+func (b *Broker) marshal(event *river.EventData) (key, value []byte, err error) {
+	if m, ok := b.BrokerHandler.(MessageMarshaler); ok {
+		return m.MarshalMessage(event)
+	}
+	value, err = b.Marshal(event)
+	return nil, value, err
+}
+
+// Committed 返回kafka已经确认的位置, 同步发送时所有交给 OnEvent 的 event 都已确认.
+// 按事务批量发送时, 不超过缓存的事务开始前的位置
+func (b *Broker) Committed(handled mysql.Position) mysql.Position {
+	if pos, ok := b.batchBefore(); ok {
+		handled = pos
+	}
+	if len(handled.Name) == 0 {
+		return handled
+	}
+	return b.producer.committed(handled)
+}
+
+// InFlight 返回已发送但kafka还未确认的消息数
+func (b *Broker) InFlight() int {
+	return b.producer.inFlight()
+}
+
+// OnClose 等待未确认的消息发送完成后调用 BrokerHandler.OnClose
+func (b *Broker) OnClose(r *river.River) {
+	if err := b.producer.close(); err != nil {
+		river.Logger.Errorf("close kafka producer error: %s", errors.ErrorStack(err))
+	}
+	if b.topics != nil {
+		if err := b.topics.close(); err != nil {
+			river.Logger.Errorf("close kafka cluster admin error: %s", errors.ErrorStack(err))
+		}
+	}
+	b.BrokerHandler.OnClose(r)
 }
 
-func (b *Broker) useStoredOffsetIfExists(partition int32, offset int64) (int64, error) {
-	offsetByte, err := b.offsetStore.Get(b.config.Topic, partition)
+func (b *Broker) useStoredOffsetIfExists(topic string, partition int32, offset int64) (int64, error) {
+	offsetByte, err := b.offsetStore.Get(topic, partition)
 	if err != nil {
 		return 0, errors.Trace(err)
 	}
 	if len(offsetByte) > 0 {
 		offset = int64(binary.LittleEndian.Uint64(offsetByte))
 	}
-	river.Logger.Debugf("load partition %d offset: %d", partition, offset)
+	river.Logger.Debugf("load topic %s partition %d offset: %d", topic, partition, offset)
 	return offset, nil
 }
 
-// Pipe 将river中的数据流向kafka
-func (b *Broker) Pipe(river *river.River, from river.From) error {
-	if err := river.SetHandler(b).Sync(from); err != nil {
+// Pipe 将river中的数据流向kafka. 使用事务生产者且 from 为 river.FromFile 时,
+// 从kafka中最后提交的位置开始解析, 避免river保存位置前重启导致重复发送
+func (b *Broker) Pipe(r *river.River, from river.From) error {
+	r.SetHandler(b)
+	if b.config.Transactional && from == river.FromFile {
+		pos, err := b.Position()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if len(pos.Name) != 0 {
+			river.Logger.Infof("sync from kafka committed position %s", pos)
+			return errors.Trace(r.SyncFrom(pos))
+		}
+	}
+	if err := r.Sync(from); err != nil {
 		return errors.Trace(err)
 	}
 	return nil
 }
 
-// Consume 消费kafka中的数据
-func (b *Broker) Consume(f func(msg *sarama.ConsumerMessage) error) error {
+// Consume 消费kafka中的数据, 直到ctx结束或消费失败. 设置了 TopicTemplate 时消费 ControlTopic
+func (b *Broker) Consume(ctx context.Context, f func(msg *sarama.ConsumerMessage) error) error {
+	return b.consumeTopic(ctx, b.config.controlTopic(), f)
+}
+
+// ConsumeTable 消费某个表的数据, 需要设置 TopicTemplate
+func (b *Broker) ConsumeTable(ctx context.Context, db, table string, f func(msg *sarama.ConsumerMessage) error) error {
+	if len(b.config.TopicTemplate) == 0 {
+		return fmt.Errorf("topic template is empty")
+	}
+	return b.consumeTopic(ctx, TopicName(b.config.TopicTemplate, db, table), f)
+}
+
+func (b *Broker) consumeTopic(ctx context.Context, topic string, f func(msg *sarama.ConsumerMessage) error) error {
+	f = b.withErrorPolicy(ctx, f)
+	if len(b.config.GroupID) != 0 {
+		return ConsumeGroup(ctx, b.config.Addrs, b.saramaConfig, b.config.GroupID, []string{topic}, f)
+	}
+
 	offsetGetter := func(partition int32) (offset int64, err error) {
 		offset = b.config.GetOffset()
-		offset, err = b.useStoredOffsetIfExists(partition, offset)
+		offset, err = b.useStoredOffsetIfExists(topic, partition, offset)
 		if err != nil {
 			return 0, errors.Trace(err)
 		}
@@ -151,11 +372,39 @@ func (b *Broker) Consume(f func(msg *sarama.ConsumerMessage) error) error {
 		if err := f(msg); err != nil {
 			return errors.Trace(err)
 		}
-		if err := b.offsetStore.Put(msg.Topic, msg.Partition, msg.Offset); err != nil {
+		// 保存下一条要消费的消息, 重启后不会重复消费该消息
+		if err := b.offsetStore.Put(msg.Topic, msg.Partition, msg.Offset+1); err != nil {
 			return errors.Trace(err)
 		}
 		return nil
 	}
 
-	return Consume(b.config.Addrs, b.config.Topic, offsetGetter, consumer)
+	return Consume(ctx, b.config.Addrs, b.saramaConfig, topic, offsetGetter, consumer)
+}
+
+// withErrorPolicy 按照 ConsumeErrorPolicy 重试f, 仍失败时 skip 跳过该消息, stop 停止消费
+func (b *Broker) withErrorPolicy(ctx context.Context, f func(msg *sarama.ConsumerMessage) error) func(msg *sarama.ConsumerMessage) error {
+	policy := b.config.ConsumeErrorPolicy
+	if policy == nil {
+		return f
+	}
+	return func(msg *sarama.ConsumerMessage) error {
+		err := policy.Retry(ctx, func() error { return f(msg) })
+		if err == nil || ctx.Err() != nil || policy.Action != river.ErrorActionSkip {
+			return err
+		}
+		river.Logger.Errorf("skip message of topic %s partition %d offset %d: %s", msg.Topic, msg.Partition, msg.Offset, err)
+		return nil
+	}
+}
+
+func checkConsumeErrorPolicy(policy *river.ErrorPolicyConfig) error {
+	if policy == nil {
+		return nil
+	}
+	switch policy.Action {
+	case "", river.ErrorActionStop, river.ErrorActionSkip:
+		return nil
+	}
+	return fmt.Errorf("unsupported consume error action: %s", policy.Action)
 }
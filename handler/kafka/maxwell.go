@@ -0,0 +1,263 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/juju/errors"
+	"github.com/obgnail/mysql-river/river"
+	"strings"
+)
+
+// MaxwellHandler 以 Maxwell 的json格式生成消息. 每行变更一条消息, 同一个事务的消息 xid 相同,
+// 最后一条消息的 commit 为 true, 其余消息的 xoffset 为在事务中的序号. xid 由事务提交的位置生成, 不是 mysql 的 xid.
+// ddl 的 type 为 database-create、table-alter 等, 无法识别的ddl不发送
+type MaxwellHandler struct {
+	DefaultHandler
+}
+
+var (
+	_ BrokerHandler  = (*MaxwellHandler)(nil)
+	_ BatchMarshaler = (*MaxwellHandler)(nil)
+	_ Unmarshaler    = (*MaxwellHandler)(nil)
+)
+
+func NewMaxwellHandler() *MaxwellHandler {
+	return &MaxwellHandler{}
+}
+
+type MaxwellMessage struct {
+	Database string                 `json:"database"`
+	Table    string                 `json:"table"`
+	Type     string                 `json:"type"`
+	Ts       int64                  `json:"ts"` // 行变更为秒, ddl 为毫秒
+	Xid      int64                  `json:"xid,omitempty"`
+	Xoffset  *int                   `json:"xoffset,omitempty"`
+	Commit   bool                   `json:"commit,omitempty"`
+	Position string                 `json:"position"`
+	ServerID uint32                 `json:"server_id"`
+	Gtid     string                 `json:"gtid,omitempty"`
+	SQL      string                 `json:"sql,omitempty"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+	Old      map[string]interface{} `json:"old,omitempty"` // update 变更前的值, 只包含变更的字段
+}
+
+func (h *MaxwellHandler) String() string {
+	return "kafka broker maxwell handler"
+}
+
+// Marshal 单独生成一个event的消息, Broker 使用 MarshalBatch
+func (h *MaxwellHandler) Marshal(event *river.EventData) ([]byte, error) {
+	msgs, err := h.MarshalBatch([]*river.EventData{event})
+	if err != nil || len(msgs) == 0 {
+		return nil, errors.Trace(err)
+	}
+	return msgs[0].Value, nil
+}
+
+func (h *MaxwellHandler) MarshalBatch(events []*river.EventData) ([]*Message, error) {
+	if len(events) == 0 {
+		return nil, nil
+	}
+	xid := transactionID(events[len(events)-1])
+
+	var rows []*MaxwellMessage
+	var rowEvents []*river.EventData
+	var msgs []*Message
+	for _, event := range events {
+		switch event.EventType {
+		case river.EventTypeInsert, river.EventTypeUpdate, river.EventTypeDelete:
+			rows = append(rows, newMaxwellRow(event, xid))
+			rowEvents = append(rowEvents, event)
+		case river.EventTypeDDL:
+			typ := maxwellDDLType(event.SQL)
+			if len(typ) == 0 {
+				continue
+			}
+			b, err := json.Marshal(&MaxwellMessage{
+				Database: event.Db,
+				Table:    event.Table,
+				Type:     typ,
+				Ts:       int64(event.Timestamp) * 1000,
+				Position: event.Position(),
+				ServerID: event.ServerID,
+				Gtid:     event.GTIDSet,
+				SQL:      event.SQL,
+			})
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			msgs = append(msgs, &Message{Event: event, Value: b})
+		}
+	}
+
+	rowMsgs := make([]*Message, 0, len(rows))
+	for i, row := range rows {
+		if i == len(rows)-1 {
+			row.Commit = true
+		} else {
+			offset := i
+			row.Xoffset = &offset
+		}
+		b, err := json.Marshal(row)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		rowMsgs = append(rowMsgs, &Message{Event: rowEvents[i], Value: b})
+	}
+	return append(rowMsgs, msgs...), nil
+}
+
+// maxwellDDLType 返回 Maxwell 中ddl的类型, index、rename、truncate 视为 table-alter
+func maxwellDDLType(sql string) string {
+	action, object := ddlStatement(sql)
+	switch object {
+	case "database":
+		if action == "create" || action == "drop" || action == "alter" {
+			return "database-" + action
+		}
+	case "table":
+		if action == "create" || action == "drop" {
+			return "table-" + action
+		}
+		return "table-alter"
+	case "index":
+		return "table-alter"
+	}
+	return ""
+}
+
+func newMaxwellRow(event *river.EventData, xid int64) *MaxwellMessage {
+	msg := &MaxwellMessage{
+		Database: event.Db,
+		Table:    event.Table,
+		Type:     event.EventType,
+		Ts:       int64(event.Timestamp),
+		Xid:      xid,
+		Position: event.Position(),
+		ServerID: event.ServerID,
+		Gtid:     event.GTIDSet,
+	}
+	row := event.After
+	if event.EventType == river.EventTypeDelete {
+		row = event.Before
+	}
+	msg.Data = maxwellRow(event, row)
+	if event.EventType == river.EventTypeUpdate {
+		msg.Old = make(map[string]interface{})
+		for field, value := range maxwellRow(event, event.Before) {
+			if after, ok := msg.Data[field]; !ok || !maxwellValueEqual(after, value) {
+				msg.Old[field] = value
+			}
+		}
+	}
+	return msg
+}
+
+func maxwellRow(event *river.EventData, row map[string]interface{}) map[string]interface{} {
+	res := make(map[string]interface{}, len(row))
+	for field, value := range row {
+		res[field] = maxwellValue(event.Column(field), value)
+	}
+	return res
+}
+
+func maxwellValueEqual(a, b interface{}) bool {
+	x, err1 := json.Marshal(a)
+	y, err2 := json.Marshal(b)
+	return err1 == nil && err2 == nil && bytes.Equal(x, y)
+}
+
+// maxwellValue 与 Maxwell 相同: decimal 为数字, enum 为字符串, set 为字符串数组, json 字段为json对象,
+// text 为字符串, 二进制数据为 base64 字符串
+func maxwellValue(c *river.Column, value interface{}) interface{} {
+	if value == nil || c == nil {
+		if b, ok := value.([]byte); ok {
+			return string(b)
+		}
+		return value
+	}
+	switch c.Type {
+	case river.ColumnTypeEnum:
+		if i, ok := toInt64(value); ok {
+			return enumValue(c.RawType, i)
+		}
+	case river.ColumnTypeSet:
+		if i, ok := toInt64(value); ok {
+			return setValues(c.RawType, i)
+		}
+	case river.ColumnTypeDecimal:
+		if s, ok := value.(string); ok {
+			return json.Number(s)
+		}
+	case river.ColumnTypeJSON:
+		var b []byte
+		switch v := value.(type) {
+		case []byte:
+			b = v
+		case string:
+			b = []byte(v)
+		}
+		if json.Valid(b) {
+			return json.RawMessage(b)
+		}
+	}
+	if b, ok := value.([]byte); ok && !c.IsBinary() {
+		return string(b)
+	}
+	return value
+}
+
+// Unmarshal 将 Maxwell 的消息还原为 EventData. update 变更前的值由 data 和 old 合并得到;
+// 消息中没有主键和字段定义, Primary 和 Columns 为空, 整数还原为 int64, 浮点数还原为 float64
+func (h *MaxwellHandler) Unmarshal(b []byte) (*river.EventData, error) {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	msg := &MaxwellMessage{}
+	if err := decoder.Decode(msg); err != nil {
+		return nil, errors.Trace(err)
+	}
+	event := &river.EventData{
+		ServerID:  msg.ServerID,
+		Db:        msg.Database,
+		Table:     msg.Table,
+		GTIDSet:   msg.Gtid,
+		Timestamp: uint32(msg.Ts),
+		Before:    map[string]interface{}{},
+		After:     map[string]interface{}{},
+	}
+	if len(msg.Position) != 0 {
+		pos, err := river.ParsePosition(msg.Position)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		event.LogName, event.LogPos = pos.Name, pos.Pos
+	}
+
+	data := normalizeNumbers(msg.Data)
+	switch msg.Type {
+	case river.EventTypeInsert:
+		event.EventType = river.EventTypeInsert
+		event.After = data
+	case river.EventTypeDelete:
+		event.EventType = river.EventTypeDelete
+		event.Before = data
+	case river.EventTypeUpdate:
+		event.EventType = river.EventTypeUpdate
+		event.After = data
+		for field, value := range data {
+			event.Before[field] = value
+		}
+		for field, value := range normalizeNumbers(msg.Old) {
+			event.Before[field] = value
+		}
+	default:
+		if !strings.HasPrefix(msg.Type, "database-") && !strings.HasPrefix(msg.Type, "table-") {
+			return nil, fmt.Errorf("unknown maxwell type: %s", msg.Type)
+		}
+		event.EventType = river.EventTypeDDL
+		event.SQL = msg.SQL
+		event.Timestamp = uint32(msg.Ts / 1000)
+	}
+	return event, nil
+}
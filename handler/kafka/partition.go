@@ -0,0 +1,85 @@
+package kafka
+
+import (
+	"fmt"
+	"github.com/Shopify/sarama"
+	"github.com/obgnail/mysql-river/river"
+	"strings"
+)
+
+const (
+	PartitionerRandom  = "random"  // 随机分区, 不保证顺序
+	PartitionerTable   = "table"   // 按 db.table 分区, 同一个表的变更有序
+	PartitionerPrimary = "primary" // 按 db.table 和主键的值分区, 同一行的变更有序, 没有主键的表按 db.table 分区
+	PartitionerCustom  = "custom"  // 按 Config.PartitionKey 返回的key分区
+)
+
+// partitionKeyFunc 返回消息的key, 返回nil时随机分区
+type partitionKeyFunc func(event *river.EventData) []byte
+
+func (c *Config) partitionKeyFunc() (partitionKeyFunc, error) {
+	switch c.partitioner() {
+	case PartitionerRandom:
+		return nil, nil
+	case PartitionerTable:
+		return TableKey, nil
+	case PartitionerPrimary:
+		return PrimaryKey, nil
+	case PartitionerCustom:
+		if c.PartitionKey == nil {
+			return nil, fmt.Errorf("partitioner is custom but PartitionKey is nil")
+		}
+		return c.PartitionKey, nil
+	}
+	return nil, fmt.Errorf("invalid partitioner: %s", c.Partitioner)
+}
+
+func (c *Config) partitioner() string {
+	if len(c.Partitioner) != 0 {
+		return c.Partitioner
+	}
+	if c.PartitionKey != nil {
+		return PartitionerCustom
+	}
+	return PartitionerRandom
+}
+
+func (c *Config) saramaPartitioner() sarama.PartitionerConstructor {
+	if c.partitioner() == PartitionerRandom {
+		return sarama.NewRandomPartitioner
+	}
+	// key为nil的消息随机分区
+	return sarama.NewHashPartitioner
+}
+
+// TableKey 返回 db.table, 不属于任何表的event(gtid、xid等)返回nil
+func TableKey(event *river.EventData) []byte {
+	if len(event.Table) == 0 {
+		return nil
+	}
+	return []byte(event.Db + "." + event.Table)
+}
+
+// PrimaryKey 返回 db.table:主键的值, 联合主键的值以逗号分隔. delete使用变更前的值, 其余使用变更后的值
+func PrimaryKey(event *river.EventData) []byte {
+	key := TableKey(event)
+	if key == nil || len(event.Primary) == 0 {
+		return key
+	}
+	row := event.After
+	if event.EventType == river.EventTypeDelete {
+		row = event.Before
+	}
+	values := make([]string, 0, len(event.Primary))
+	for _, field := range event.Primary {
+		value, ok := row[field]
+		if !ok {
+			return key
+		}
+		if b, ok := value.([]byte); ok {
+			value = string(b)
+		}
+		values = append(values, fmt.Sprint(value))
+	}
+	return []byte(string(key) + ":" + strings.Join(values, ","))
+}
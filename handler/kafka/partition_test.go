@@ -0,0 +1,57 @@
+package kafka
+
+import (
+	"github.com/obgnail/mysql-river/river"
+	"testing"
+)
+
+func TestPartitionKey(t *testing.T) {
+	cases := []struct {
+		event *river.EventData
+		table string
+		pk    string
+	}{
+		{
+			event: &river.EventData{EventType: river.EventTypeInsert, Db: "db", Table: "user",
+				Primary: []string{"id"}, After: map[string]interface{}{"id": 1}},
+			table: "db.user", pk: "db.user:1",
+		},
+		{
+			event: &river.EventData{EventType: river.EventTypeDelete, Db: "db", Table: "user",
+				Primary: []string{"id", "name"}, Before: map[string]interface{}{"id": 1, "name": []byte("lihua")}},
+			table: "db.user", pk: "db.user:1,lihua",
+		},
+		{
+			event: &river.EventData{EventType: river.EventTypeUpdate, Db: "db", Table: "log",
+				After: map[string]interface{}{"msg": "x"}},
+			table: "db.log", pk: "db.log",
+		},
+		{
+			event: &river.EventData{EventType: river.EventTypeXID},
+		},
+	}
+	for _, c := range cases {
+		if got := string(TableKey(c.event)); got != c.table {
+			t.Errorf("TableKey: got %q, want %q", got, c.table)
+		}
+		if got := string(PrimaryKey(c.event)); got != c.pk {
+			t.Errorf("PrimaryKey: got %q, want %q", got, c.pk)
+		}
+	}
+}
+
+func TestConfig_PartitionKeyFunc(t *testing.T) {
+	if f, err := (&Config{}).partitionKeyFunc(); err != nil || f != nil {
+		t.Errorf("default partitioner should be random, got %v", err)
+	}
+	if _, err := (&Config{Partitioner: PartitionerCustom}).partitionKeyFunc(); err == nil {
+		t.Error("expect error for custom partitioner without PartitionKey")
+	}
+	if _, err := (&Config{Partitioner: "unknown"}).partitionKeyFunc(); err == nil {
+		t.Error("expect error for unknown partitioner")
+	}
+	config := &Config{PartitionKey: func(*river.EventData) []byte { return []byte("k") }}
+	if f, err := config.partitionKeyFunc(); err != nil || string(f(nil)) != "k" {
+		t.Errorf("PartitionKey should imply custom partitioner, got %v", err)
+	}
+}
@@ -0,0 +1,173 @@
+package kafka
+
+import (
+	"fmt"
+	"github.com/Shopify/sarama"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/juju/errors"
+	"sync"
+)
+
+const defaultMaxInFlight = 10000
+
+var errProducerClosed = fmt.Errorf("kafka producer is closed")
+
+// producer 发送消息并记录未确认的消息, 用于向river确认可以保存的位置
+type producer interface {
+	// send 发送event对应的消息, msg为nil表示该event不需要发送
+	send(pos mysql.Position, msg *sarama.ProducerMessage) error
+	committed(handled mysql.Position) mysql.Position
+	inFlight() int
+	close() error
+}
+
+// syncProducer 每条消息都等待kafka确认, 没有未确认的消息
+type syncProducer struct {
+	producer sarama.SyncProducer
+}
+
+func (p *syncProducer) send(_ mysql.Position, msg *sarama.ProducerMessage) error {
+	if msg == nil {
+		return nil
+	}
+	_, _, err := p.producer.SendMessage(msg)
+	return errors.Trace(err)
+}
+
+func (p *syncProducer) committed(handled mysql.Position) mysql.Position {
+	return handled
+}
+
+func (p *syncProducer) inFlight() int {
+	return 0
+}
+
+func (p *syncProducer) close() error {
+	return errors.Trace(p.producer.Close())
+}
+
+// pending 按发送顺序记录的event
+type pending struct {
+	pos   mysql.Position
+	acked bool
+}
+
+// asyncProducer 批量异步发送消息. 只有某个event之前的消息都被kafka确认后, 才会将该event的位置确认给river.
+// 发送失败后(sarama内部重试之后)不再接受新的消息, river 重启后从最后确认的位置重新发送.
+type asyncProducer struct {
+	producer sarama.AsyncProducer
+	slots    chan struct{} // 限制未确认的消息数
+
+	sync.Mutex
+	pendings  []*pending
+	lastAcked mysql.Position // 该位置及之前的消息都已确认
+	err       error
+
+	// inputMu 使向 Input 发送消息和 close 互斥, close 之后 Input 已关闭, 不能再发送.
+	// 不使用上面的锁: Input 阻塞时 loopSuccesses 仍需要加锁确认消息
+	inputMu sync.Mutex
+	closed  bool
+
+	wg sync.WaitGroup
+}
+
+func newAsyncProducer(addrs []string, cfg *sarama.Config, maxInFlight int) (*asyncProducer, error) {
+	if maxInFlight <= 0 {
+		maxInFlight = defaultMaxInFlight
+	}
+	client, err := sarama.NewAsyncProducer(addrs, cfg)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	p := &asyncProducer{producer: client, slots: make(chan struct{}, maxInFlight)}
+	p.wg.Add(2)
+	go p.loopSuccesses()
+	go p.loopErrors()
+	return p, nil
+}
+
+func (p *asyncProducer) loopSuccesses() {
+	defer p.wg.Done()
+	for msg := range p.producer.Successes() {
+		p.Lock()
+		msg.Metadata.(*pending).acked = true
+		p.Unlock()
+		<-p.slots
+	}
+}
+
+func (p *asyncProducer) loopErrors() {
+	defer p.wg.Done()
+	for e := range p.producer.Errors() {
+		p.Lock()
+		if p.err == nil {
+			p.err = fmt.Errorf("deliver message of position %s error: %s",
+				e.Msg.Metadata.(*pending).pos, e.Err)
+		}
+		p.Unlock()
+		<-p.slots
+	}
+}
+
+func (p *asyncProducer) send(pos mysql.Position, msg *sarama.ProducerMessage) error {
+	p.Lock()
+	if p.err != nil {
+		p.Unlock()
+		return p.err
+	}
+	if msg == nil {
+		// 没有未确认的消息时该位置可以直接确认
+		if len(p.pendings) == 0 {
+			p.lastAcked = pos
+		} else {
+			p.pendings = append(p.pendings, &pending{pos: pos, acked: true})
+		}
+		p.Unlock()
+		return nil
+	}
+	pd := &pending{pos: pos}
+	p.pendings = append(p.pendings, pd)
+	p.Unlock()
+
+	p.slots <- struct{}{} // 未确认的消息达到上限时阻塞
+	msg.Metadata = pd
+	// river 可能在 OnEvent 的同时在其他协程中关闭
+	p.inputMu.Lock()
+	defer p.inputMu.Unlock()
+	if p.closed {
+		<-p.slots
+		return errProducerClosed
+	}
+	p.producer.Input() <- msg
+	return nil
+}
+
+func (p *asyncProducer) committed(handled mysql.Position) mysql.Position {
+	p.Lock()
+	defer p.Unlock()
+	i := 0
+	for ; i < len(p.pendings) && p.pendings[i].acked; i++ {
+		p.lastAcked = p.pendings[i].pos
+	}
+	p.pendings = p.pendings[i:]
+	if len(p.pendings) == 0 && p.err == nil {
+		return handled
+	}
+	return p.lastAcked
+}
+
+func (p *asyncProducer) inFlight() int {
+	return len(p.slots)
+}
+
+// close 等待所有消息发送完成. 不能使用 Close, 它会和 loopSuccesses 争抢确认消息
+func (p *asyncProducer) close() error {
+	p.inputMu.Lock()
+	p.closed = true
+	p.producer.AsyncClose()
+	p.inputMu.Unlock()
+	p.wg.Wait()
+	p.Lock()
+	defer p.Unlock()
+	return errors.Trace(p.err)
+}
@@ -0,0 +1,149 @@
+package kafka
+
+import (
+	"fmt"
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/obgnail/mysql-river/river"
+	"testing"
+	"time"
+)
+
+func TestAsyncProducer_Committed(t *testing.T) {
+	pos := func(p uint32) mysql.Position { return mysql.Position{Name: "mysql-bin.000001", Pos: p} }
+	p := &asyncProducer{}
+	if err := p.send(pos(100), nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.committed(pos(100)); got != pos(100) {
+		t.Errorf("got %s, want %s", got, pos(100))
+	}
+
+	// 模拟已发送但未确认的消息
+	p.pendings = []*pending{{pos: pos(200)}, {pos: pos(300)}}
+	if err := p.send(pos(400), nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.committed(pos(400)); got != pos(100) {
+		t.Errorf("got %s, want %s", got, pos(100))
+	}
+	p.pendings[1].acked = true
+	if got := p.committed(pos(400)); got != pos(100) {
+		t.Errorf("acked out of order, got %s, want %s", got, pos(100))
+	}
+	p.pendings[0].acked = true
+	if got := p.committed(pos(500)); got != pos(500) {
+		t.Errorf("all acked, got %s, want %s", got, pos(500))
+	}
+
+	p.pendings = []*pending{{pos: pos(600), acked: true}, {pos: pos(700)}}
+	p.err = fmt.Errorf("deliver error")
+	if got := p.committed(pos(800)); got != pos(600) {
+		t.Errorf("got %s, want %s", got, pos(600))
+	}
+	if err := p.send(pos(900), nil); err == nil {
+		t.Error("expect sticky delivery error")
+	}
+}
+
+func TestAsyncProducer_SendAfterClose(t *testing.T) {
+	cfg := sarama.NewConfig()
+	cfg.Producer.Return.Successes = true
+	mock := mocks.NewAsyncProducer(t, cfg)
+	mock.ExpectInputAndSucceed()
+	p := &asyncProducer{producer: mock, slots: make(chan struct{}, 1)}
+	p.wg.Add(2)
+	go p.loopSuccesses()
+	go p.loopErrors()
+
+	pos := mysql.Position{Name: "mysql-bin.000001", Pos: 100}
+	if err := p.send(pos, &sarama.ProducerMessage{Topic: "binlog"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.close(); err != nil {
+		t.Fatal(err)
+	}
+	// river 在 OnEvent 的同时关闭时, 发送不能 panic, 也不能占用未确认消息的名额
+	if err := p.send(pos, &sarama.ProducerMessage{Topic: "binlog"}); err != errProducerClosed {
+		t.Errorf("got %v, want %v", err, errProducerClosed)
+	}
+	if n := p.inFlight(); n != 0 {
+		t.Errorf("in flight: %d", n)
+	}
+}
+
+func TestNewSaramaConfig(t *testing.T) {
+	if _, err := NewSaramaConfig(&Config{Compression: "gzip"}); err != nil {
+		t.Error(err)
+	}
+	if _, err := NewSaramaConfig(&Config{Compression: "unknown"}); err == nil {
+		t.Error("expect error for unknown compression")
+	}
+	if _, err := NewSaramaConfig(&Config{Compression: "zstd"}); err == nil {
+		t.Error("expect error for zstd before kafka 2.1.0")
+	}
+
+	cfg, err := NewSaramaConfig(&Config{
+		Compression:     "zstd",
+		ClientID:        "river",
+		Version:         "2.8.0",
+		TLS:             &river.TLSConfig{SkipVerify: true},
+		SASL:            &SASLConfig{Mechanism: "scram-sha-512", User: "u", Password: "p"},
+		MaxMessageBytes: 2 << 20,
+		MaxRetries:      -1,
+		RetryBackoff:    time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ClientID != "river" || cfg.Version != sarama.V2_8_0_0 || !cfg.Net.TLS.Enable || !cfg.Net.TLS.Config.InsecureSkipVerify ||
+		cfg.Net.SASL.Mechanism != SASLScramSHA512 || cfg.Net.SASL.SCRAMClientGeneratorFunc == nil ||
+		cfg.Producer.MaxMessageBytes != 2<<20 || cfg.Producer.Retry.Max != 0 || cfg.Consumer.Retry.Backoff != time.Second {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+	if _, err := NewSaramaConfig(&Config{SASL: &SASLConfig{Mechanism: "GSSAPI"}}); err == nil {
+		t.Error("expect error for unsupported sasl mechanism")
+	}
+	if _, err := NewSaramaConfig(&Config{Version: "x"}); err == nil {
+		t.Error("expect error for invalid version")
+	}
+}
+
+// recordProducer 记录发送的消息
+type recordProducer struct {
+	syncProducer
+	msgs []*sarama.ProducerMessage
+}
+
+func (p *recordProducer) send(_ mysql.Position, msg *sarama.ProducerMessage) error {
+	if msg != nil {
+		p.msgs = append(p.msgs, msg)
+	}
+	return nil
+}
+
+func TestBroker_OnEventKey(t *testing.T) {
+	event := &river.EventData{EventType: river.EventTypeInsert, Db: "db", Table: "user",
+		Primary: []string{"id"}, After: map[string]interface{}{"id": 1}}
+	cases := []struct {
+		handler BrokerHandler
+		key     string
+	}{
+		{&DefaultHandler{}, "db.user"},
+		{NewDebeziumHandler("s"), `{"id":1}`},
+	}
+	for _, c := range cases {
+		p := &recordProducer{}
+		b := &Broker{config: &Config{Topic: "binlog"}, producer: p, keyFunc: TableKey, BrokerHandler: c.handler}
+		if err := b.OnEvent(event); err != nil {
+			t.Fatal(err)
+		}
+		if len(p.msgs) != 1 {
+			t.Fatalf("%s: got %d messages", c.handler, len(p.msgs))
+		}
+		if key, _ := p.msgs[0].Key.Encode(); string(key) != c.key {
+			t.Errorf("%s: got key %s, want %s", c.handler, key, c.key)
+		}
+	}
+}
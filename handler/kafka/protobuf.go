@@ -0,0 +1,172 @@
+package kafka
+
+import (
+	"fmt"
+	"github.com/juju/errors"
+	"github.com/obgnail/mysql-river/river"
+	"math"
+	"strings"
+)
+
+// protobuf 的 wire type
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+	protoFixed32 = 5
+)
+
+// ProtobufHandler 以 Protobuf(proto3) 二进制格式生成消息, schema 由表定义生成: package 为 {Namespace}.{db}.{table},
+// 消息为 Envelope, 包含 op、db、table、log_name、log_pos、gtid、ts 和 before、after 两个 Row;
+// Row 的字段为 optional, 编号为字段在表定义中的序号(从1开始), NULL 不编码. 只发送行变更, ddl、gtid、xid 等event不发送.
+// 设置了 Registry 时注册schema并使用 Confluent 的 wire format, 否则只包含 Protobuf 数据
+type ProtobufHandler struct {
+	DefaultHandler
+	Namespace string
+	Registry  SchemaRegistry
+	Subject   func(event *river.EventData) string // 注册schema的subject, 默认为 Envelope 的全名
+}
+
+var _ BrokerHandler = (*ProtobufHandler)(nil)
+
+func NewProtobufHandler(namespace string, registry SchemaRegistry) *ProtobufHandler {
+	return &ProtobufHandler{Namespace: namespace, Registry: registry}
+}
+
+func (h *ProtobufHandler) String() string {
+	return "kafka broker protobuf handler"
+}
+
+func (h *ProtobufHandler) Unmarshal([]byte) (*river.EventData, error) {
+	return nil, fmt.Errorf("protobuf message can not be unmarshalled without schema")
+}
+
+// Schema 返回表对应的 proto 文件
+func (h *ProtobufHandler) Schema(event *river.EventData) string {
+	return h.schema(event, schemaFields(event))
+}
+
+func (h *ProtobufHandler) schema(event *river.EventData, fields []*schemaField) string {
+	var s strings.Builder
+	s.WriteString("syntax = \"proto3\";\n")
+	fmt.Fprintf(&s, "package %s;\n\n", schemaNamespace(h.Namespace, event))
+	s.WriteString(`message Envelope {
+  string op = 1;
+  string db = 2;
+  string table = 3;
+  string log_name = 4;
+  uint64 log_pos = 5;
+  string gtid = 6;
+  int64 ts = 7;
+  Row before = 8;
+  Row after = 9;
+}
+
+message Row {
+`)
+	for i, f := range fields {
+		fmt.Fprintf(&s, "  optional %s %s = %d;\n", protoType(f.Kind), schemaName(f.Name), i+1)
+	}
+	s.WriteString("}\n")
+	return s.String()
+}
+
+func protoType(kind fieldKind) string {
+	switch kind {
+	case kindInt:
+		return "int32"
+	case kindLong:
+		return "int64"
+	case kindUlong:
+		return "uint64"
+	}
+	return kind.String()
+}
+
+func (h *ProtobufHandler) Marshal(event *river.EventData) ([]byte, error) {
+	if !isRowEvent(event) {
+		return nil, nil
+	}
+	fields := schemaFields(event)
+	var b []byte
+	for i, s := range []string{event.EventType, event.Db, event.Table, event.LogName} {
+		b = appendProtoString(b, i+1, s)
+	}
+	if event.LogPos != 0 {
+		b = appendProtoVarint(b, 5, uint64(event.LogPos))
+	}
+	b = appendProtoString(b, 6, event.GTIDSet)
+	if event.Timestamp != 0 {
+		b = appendProtoVarint(b, 7, uint64(event.Timestamp))
+	}
+	before, after := eventRows(event)
+	for i, row := range []map[string]interface{}{before, after} {
+		if row == nil {
+			continue
+		}
+		r, err := protoRow(fields, row)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		b = appendProtoBytes(b, 8+i, r)
+	}
+
+	if h.Registry == nil {
+		return b, nil
+	}
+	subject := schemaNamespace(h.Namespace, event) + ".Envelope"
+	if h.Subject != nil {
+		subject = h.Subject(event)
+	}
+	// message index 为 [0], 即 Envelope, 按 Confluent 的约定编码为一个0
+	return encodeWithSchema(h.Registry, subject, SchemaTypeProtobuf, h.schema(event, fields), []byte{0}, b)
+}
+
+func protoRow(fields []*schemaField, row map[string]interface{}) ([]byte, error) {
+	b := []byte{}
+	for i, f := range fields {
+		value, err := f.value(row)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		num := i + 1
+		switch v := value.(type) {
+		case int64: // int32 和 int64 的负数都编码为10字节
+			b = appendProtoVarint(b, num, uint64(v))
+		case uint64:
+			b = appendProtoVarint(b, num, v)
+		case float32:
+			b = appendProtoTag(b, num, protoFixed32)
+			b = appendFixed32(b, math.Float32bits(v))
+		case float64:
+			b = appendProtoTag(b, num, protoFixed64)
+			b = appendFixed64(b, math.Float64bits(v))
+		case string:
+			b = appendProtoBytes(b, num, []byte(v))
+		case []byte:
+			b = appendProtoBytes(b, num, v)
+		}
+	}
+	return b, nil
+}
+
+func appendProtoTag(b []byte, num int, wireType int) []byte {
+	return appendUvarint(b, uint64(num)<<3|uint64(wireType))
+}
+
+func appendProtoVarint(b []byte, num int, v uint64) []byte {
+	return appendUvarint(appendProtoTag(b, num, protoVarint), v)
+}
+
+func appendProtoBytes(b []byte, num int, v []byte) []byte {
+	b = appendUvarint(appendProtoTag(b, num, protoBytes), uint64(len(v)))
+	return append(b, v...)
+}
+
+// appendProtoString proto3 中值为空字符串的字段不编码
+func appendProtoString(b []byte, num int, s string) []byte {
+	if len(s) == 0 {
+		return b
+	}
+	return appendProtoBytes(b, num, []byte(s))
+}
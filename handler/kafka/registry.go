@@ -0,0 +1,138 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/juju/errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const (
+	SchemaTypeAvro     = "AVRO"
+	SchemaTypeProtobuf = "PROTOBUF"
+
+	confluentContentType = "application/vnd.schemaregistry.v1+json"
+	confluentMagicByte   = 0
+)
+
+// SchemaRegistry 注册消息的schema. AvroHandler 和 ProtobufHandler 设置了 SchemaRegistry 时,
+// 消息使用 Confluent 的 wire format: 0、4字节大端的schema id、(protobuf 的 message index)、数据
+type SchemaRegistry interface {
+	// Register 在 subject 下注册schema并返回schema的id, schema已经注册过时返回已有的id
+	Register(subject, schemaType, schema string) (int, error)
+}
+
+// ConfluentRegistry 兼容 Confluent Schema Registry REST API 的客户端, 注册过的schema缓存在内存中
+type ConfluentRegistry struct {
+	URL      string
+	Username string // 设置后使用 basic auth
+	Password string
+	Client   *http.Client // 为nil时使用 http.DefaultClient
+
+	mu  sync.Mutex
+	ids map[string]int // subject + schema -> id
+}
+
+var _ SchemaRegistry = (*ConfluentRegistry)(nil)
+
+func NewConfluentRegistry(url string) *ConfluentRegistry {
+	return &ConfluentRegistry{URL: strings.TrimRight(url, "/")}
+}
+
+type confluentSchema struct {
+	Schema     string `json:"schema"`
+	SchemaType string `json:"schemaType,omitempty"` // 为空时为 AVRO
+}
+
+type confluentResponse struct {
+	ID        int    `json:"id"`
+	ErrorCode int    `json:"error_code"`
+	Message   string `json:"message"`
+}
+
+func (r *ConfluentRegistry) Register(subject, schemaType, schema string) (int, error) {
+	key := subject + "\x00" + schema
+	r.mu.Lock()
+	id, ok := r.ids[key]
+	r.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	req := &confluentSchema{Schema: schema}
+	if schemaType != SchemaTypeAvro {
+		req.SchemaType = schemaType
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	resp, err := r.post(fmt.Sprintf("%s/subjects/%s/versions", r.URL, url.PathEscape(subject)), body)
+	if err != nil {
+		return 0, errors.Annotatef(err, "register schema of subject %s", subject)
+	}
+
+	r.mu.Lock()
+	if r.ids == nil {
+		r.ids = make(map[string]int)
+	}
+	r.ids[key] = resp.ID
+	r.mu.Unlock()
+	return resp.ID, nil
+}
+
+func (r *ConfluentRegistry) post(url string, body []byte) (*confluentResponse, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	req.Header.Set("Content-Type", confluentContentType)
+	req.Header.Set("Accept", confluentContentType)
+	if len(r.Username) != 0 {
+		req.SetBasicAuth(r.Username, r.Password)
+	}
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	res := &confluentResponse{}
+	if err := json.Unmarshal(b, res); err != nil && resp.StatusCode/100 == 2 {
+		return nil, errors.Trace(err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("schema registry: %s: error code %d: %s", resp.Status, res.ErrorCode, res.Message)
+	}
+	return res, nil
+}
+
+// encodeWithSchema 注册schema并生成 Confluent wire format 的消息; registry 为nil时直接返回 payload
+func encodeWithSchema(registry SchemaRegistry, subject, schemaType, schema string, indexes, payload []byte) ([]byte, error) {
+	if registry == nil {
+		return payload, nil
+	}
+	id, err := registry.Register(subject, schemaType, schema)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	b := make([]byte, 5, 5+len(indexes)+len(payload))
+	b[0] = confluentMagicByte
+	binary.BigEndian.PutUint32(b[1:], uint32(id))
+	b = append(b, indexes...)
+	return append(b, payload...), nil
+}
@@ -0,0 +1,70 @@
+package kafka
+
+import (
+	"fmt"
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+	"github.com/xdg-go/scram"
+	"strings"
+)
+
+const (
+	SASLPlain       = sarama.SASLTypePlaintext   // PLAIN
+	SASLScramSHA256 = sarama.SASLTypeSCRAMSHA256 // SCRAM-SHA-256
+	SASLScramSHA512 = sarama.SASLTypeSCRAMSHA512 // SCRAM-SHA-512
+)
+
+type SASLConfig struct {
+	Mechanism string `json:"mechanism" toml:"mechanism"` // PLAIN(默认)、SCRAM-SHA-256 或 SCRAM-SHA-512
+	User      string `json:"user" toml:"user"`
+	Password  string `json:"password" toml:"password"`
+}
+
+func (c *SASLConfig) apply(cfg *sarama.Config) error {
+	cfg.Net.SASL.Enable = true
+	cfg.Net.SASL.Handshake = true
+	cfg.Net.SASL.User = c.User
+	cfg.Net.SASL.Password = c.Password
+	switch strings.ToUpper(c.Mechanism) {
+	case "", SASLPlain:
+		cfg.Net.SASL.Mechanism = SASLPlain
+	case SASLScramSHA256:
+		cfg.Net.SASL.Mechanism = SASLScramSHA256
+		cfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &scramClient{hash: scram.SHA256} }
+	case SASLScramSHA512:
+		cfg.Net.SASL.Mechanism = SASLScramSHA512
+		cfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &scramClient{hash: scram.SHA512} }
+	default:
+		return fmt.Errorf("invalid sasl mechanism: %s", c.Mechanism)
+	}
+	return nil
+}
+
+// scramClient 使用 xdg-go/scram 实现 sarama.SCRAMClient
+type scramClient struct {
+	hash  scram.HashGeneratorFcn
+	nonce func() string // 为nil时随机生成
+	conv  *scram.ClientConversation
+}
+
+var _ sarama.SCRAMClient = (*scramClient)(nil)
+
+func (c *scramClient) Begin(user, password, authzID string) error {
+	client, err := c.hash.NewClient(user, password, authzID)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if c.nonce != nil {
+		client = client.WithNonceGenerator(c.nonce)
+	}
+	c.conv = client.NewConversation()
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.conv.Step(challenge)
+}
+
+func (c *scramClient) Done() bool {
+	return c.conv.Done()
+}
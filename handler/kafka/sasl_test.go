@@ -0,0 +1,41 @@
+package kafka
+
+import (
+	"github.com/xdg-go/scram"
+	"testing"
+)
+
+// 测试数据来自 RFC 7677
+func TestScramClient(t *testing.T) {
+	nonce := func() string { return "rOprNGfwEbeRWgbNEkqO" }
+	c := &scramClient{hash: scram.SHA256, nonce: nonce}
+	if err := c.Begin("user", "pencil", ""); err != nil {
+		t.Fatal(err)
+	}
+	steps := []struct {
+		challenge string
+		response  string
+	}{
+		{"", "n,,n=user,r=rOprNGfwEbeRWgbNEkqO"},
+		{"r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096",
+			"c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="},
+		{"v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=", ""},
+	}
+	for i, step := range steps {
+		got, err := c.Step(step.challenge)
+		if err != nil || got != step.response {
+			t.Fatalf("step %d: got %q, err %v", i+1, got, err)
+		}
+	}
+	if !c.Done() {
+		t.Error("should be done")
+	}
+
+	c = &scramClient{hash: scram.SHA256, nonce: nonce}
+	c.Begin("user", "pencil", "")
+	c.Step("")
+	c.Step(steps[1].challenge)
+	if _, err := c.Step("v=AAAA"); err == nil {
+		t.Error("expect error for invalid server signature")
+	}
+}
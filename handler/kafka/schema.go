@@ -0,0 +1,219 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/juju/errors"
+	"github.com/obgnail/mysql-river/river"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// fieldKind 字段在 avro 和 protobuf 中的类型
+type fieldKind int
+
+const (
+	kindInt    fieldKind = iota // tinyint、smallint、mediumint、int、year
+	kindLong                    // int unsigned、bigint、bit
+	kindUlong                   // bigint unsigned
+	kindFloat                   // float
+	kindDouble                  // double
+	kindString                  // decimal、字符串、enum、set、时间、json
+	kindBytes                   // 二进制数据
+)
+
+// schemaField 行中的一个字段
+type schemaField struct {
+	Name   string // 原始字段名
+	Column *river.Column
+	Kind   fieldKind
+}
+
+// schemaFields 按表定义的顺序返回行的字段; 没有表定义时按字段名排序, 所有字段视为字符串
+func schemaFields(event *river.EventData) []*schemaField {
+	var fields []*schemaField
+	if len(event.Columns) != 0 {
+		for _, c := range event.Columns {
+			fields = append(fields, &schemaField{Name: c.Name, Column: c, Kind: columnKind(c)})
+		}
+		return fields
+	}
+	names := make(map[string]struct{})
+	for _, row := range []map[string]interface{}{event.Before, event.After} {
+		for field := range row {
+			names[field] = struct{}{}
+		}
+	}
+	for name := range names {
+		fields = append(fields, &schemaField{Name: name, Kind: kindString})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Name < fields[j].Name })
+	return fields
+}
+
+// value 返回字段在行中的值, NULL 返回nil
+func (f *schemaField) value(row map[string]interface{}) (interface{}, error) {
+	value := row[f.Name]
+	if value == nil {
+		return nil, nil
+	}
+	v, err := kindValue(f.Column, f.Kind, value)
+	if err != nil {
+		return nil, errors.Annotatef(err, "field %s", f.Name)
+	}
+	return v, nil
+}
+
+// columnKind 根据表定义返回字段的类型
+func columnKind(c *river.Column) fieldKind {
+	if c == nil {
+		return kindString
+	}
+	raw := strings.ToLower(c.RawType)
+	switch c.Type {
+	case river.ColumnTypeNumber, river.ColumnTypeMediumInt:
+		switch {
+		case strings.HasPrefix(raw, "bigint") && c.Unsigned:
+			return kindUlong
+		case strings.HasPrefix(raw, "bigint"), strings.HasPrefix(raw, "int") && c.Unsigned:
+			return kindLong
+		}
+		return kindInt
+	case river.ColumnTypeBit:
+		return kindLong
+	case river.ColumnTypeFloat:
+		if strings.HasPrefix(raw, "float") {
+			return kindFloat
+		}
+		return kindDouble
+	}
+	if c.IsBinary() {
+		return kindBytes
+	}
+	return kindString
+}
+
+// kindValue 将字段值转换为 kind 对应的go类型: int64、uint64、float32、float64、string 或 []byte
+func kindValue(c *river.Column, kind fieldKind, value interface{}) (interface{}, error) {
+	switch kind {
+	case kindInt, kindLong:
+		if i, ok := toInt64(value); ok {
+			return i, nil
+		}
+	case kindUlong:
+		if i, ok := toInt64(value); ok {
+			return uint64(i), nil
+		}
+	case kindFloat, kindDouble:
+		f, ok := toFloat64(value)
+		if !ok {
+			break
+		}
+		if kind == kindFloat {
+			return float32(f), nil
+		}
+		return f, nil
+	case kindBytes:
+		switch v := value.(type) {
+		case []byte:
+			return v, nil
+		case string:
+			return []byte(v), nil
+		}
+	case kindString:
+		if c != nil {
+			if i, ok := toInt64(value); ok {
+				switch c.Type {
+				case river.ColumnTypeEnum:
+					return enumValue(c.RawType, i), nil
+				case river.ColumnTypeSet:
+					return strings.Join(setValues(c.RawType, i), ","), nil
+				}
+			}
+		}
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case []byte:
+			return string(v), nil
+		case float32:
+			return strconv.FormatFloat(float64(v), 'f', -1, 32), nil
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		}
+		return fmt.Sprint(value), nil
+	}
+	return nil, fmt.Errorf("can not convert %T to %s", value, kind)
+}
+
+func toFloat64(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	i, ok := toInt64(value)
+	return float64(i), ok
+}
+
+func (k fieldKind) String() string {
+	return [...]string{"int", "long", "ulong", "float", "double", "string", "bytes"}[k]
+}
+
+// schemaName 将库名、表名、字段名转换为 avro 和 protobuf 合法的名字: 只包含字母、数字和 _, 不以数字开头
+func schemaName(name string) string {
+	res := []byte(name)
+	for i, c := range res {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			res[i] = '_'
+		}
+	}
+	if len(res) == 0 || res[0] >= '0' && res[0] <= '9' {
+		return "_" + string(res)
+	}
+	return string(res)
+}
+
+// schemaNamespace 返回表的 avro namespace 和 protobuf package, 如 mysql_river.shop.user
+func schemaNamespace(namespace string, event *river.EventData) string {
+	return strings.Join([]string{schemaName(namespace), schemaName(event.Db), schemaName(event.Table)}, ".")
+}
+
+// eventRows 按 EventType 返回行变更前后的值, insert 没有 before, delete 没有 after, 不存在的一侧为nil
+func eventRows(event *river.EventData) (before, after map[string]interface{}) {
+	switch event.EventType {
+	case river.EventTypeInsert:
+		return nil, event.After
+	case river.EventTypeDelete:
+		return event.Before, nil
+	}
+	return event.Before, event.After
+}
+
+func isRowEvent(event *river.EventData) bool {
+	switch event.EventType {
+	case river.EventTypeInsert, river.EventTypeUpdate, river.EventTypeDelete:
+		return true
+	}
+	return false
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+// appendFixed32 avro 的 float 和 protobuf 的 fixed32 都是小端序
+func appendFixed32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendFixed64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
@@ -0,0 +1,267 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/obgnail/mysql-river/river"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func schemaEvent() *river.EventData {
+	return &river.EventData{
+		EventType: river.EventTypeInsert,
+		LogName:   "bin.000001",
+		LogPos:    4,
+		Db:        "shop",
+		Table:     "t",
+		Columns: []*river.Column{
+			{Name: "id", Type: river.ColumnTypeNumber, RawType: "int(11)"},
+			{Name: "name", Type: river.ColumnTypeString, RawType: "varchar(255)"},
+			{Name: "price", Type: river.ColumnTypeFloat, RawType: "float"},
+		},
+		Before:    map[string]interface{}{}, // 与 river.OnRow 相同, 没有值的一侧为空map
+		After:     map[string]interface{}{"id": int32(1), "name": "a", "price": float32(1.5)},
+		Timestamp: 1,
+	}
+}
+
+func TestAppendAvroLong(t *testing.T) {
+	for v, want := range map[int64][]byte{0: {0}, -1: {1}, 1: {2}, -64: {0x7f}, 64: {0x80, 0x01}} {
+		if got := appendAvroLong(nil, v); !bytes.Equal(got, want) {
+			t.Errorf("%d: got %x, want %x", v, got, want)
+		}
+	}
+}
+
+func TestAvroHandler_Marshal(t *testing.T) {
+	h := NewAvroHandler(defaultServerName, nil)
+	got, err := h.Marshal(schemaEvent())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0, 2, 2, 2, 2, 2, 'a', 2, 0, 0, 0xc0, 0x3f}
+	want = append(want, 12, 'i', 'n', 's', 'e', 'r', 't', 8, 's', 'h', 'o', 'p', 2, 't')
+	want = append(want, 20, 'b', 'i', 'n', '.', '0', '0', '0', '0', '0', '1', 8, 0, 2)
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %x, want %x", got, want)
+	}
+
+	// delete 的 after 为 null
+	event := schemaEvent()
+	event.EventType, event.Before, event.After = river.EventTypeDelete, event.After, map[string]interface{}{}
+	if got, err = h.Marshal(event); err != nil {
+		t.Fatal(err)
+	}
+	want = []byte{2, 2, 2, 2, 2, 'a', 2, 0, 0, 0xc0, 0x3f, 0, 12, 'd', 'e', 'l', 'e', 't', 'e'}
+	if !bytes.HasPrefix(got, want) {
+		t.Errorf("delete: got %x, want prefix %x", got, want)
+	}
+
+	schema, err := h.Schema(schemaEvent())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(schema), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record["namespace"] != "mysql_river.shop.t" || record["name"] != "Envelope" {
+		t.Errorf("unexpected schema: %s", schema)
+	}
+
+	if b, err := h.Marshal(&river.EventData{EventType: river.EventTypeXID}); err != nil || b != nil {
+		t.Errorf("xid: got %x, err %v", b, err)
+	}
+}
+
+func TestProtobufHandler_Marshal(t *testing.T) {
+	h := NewProtobufHandler(defaultServerName, nil)
+	got, err := h.Marshal(schemaEvent())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x0a, 6, 'i', 'n', 's', 'e', 'r', 't', 0x12, 4, 's', 'h', 'o', 'p', 0x1a, 1, 't'}
+	want = append(want, 0x22, 10, 'b', 'i', 'n', '.', '0', '0', '0', '0', '0', '1', 0x28, 4, 0x38, 1)
+	want = append(want, 0x4a, 10, 0x08, 1, 0x12, 1, 'a', 0x1d, 0, 0, 0xc0, 0x3f)
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %x, want %x", got, want)
+	}
+
+	// delete 只有 before(字段8)
+	event := schemaEvent()
+	event.EventType, event.Before, event.After = river.EventTypeDelete, event.After, map[string]interface{}{}
+	if got, err = h.Marshal(event); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0x42, 10, 0x08, 1, 0x12, 1, 'a', 0x1d, 0, 0, 0xc0, 0x3f}; !bytes.HasSuffix(got, want) {
+		t.Errorf("delete: got %x, want suffix %x", got, want)
+	}
+
+	wantSchema := `syntax = "proto3";
+package mysql_river.shop.t;
+
+message Envelope {
+  string op = 1;
+  string db = 2;
+  string table = 3;
+  string log_name = 4;
+  uint64 log_pos = 5;
+  string gtid = 6;
+  int64 ts = 7;
+  Row before = 8;
+  Row after = 9;
+}
+
+message Row {
+  optional int32 id = 1;
+  optional string name = 2;
+  optional float price = 3;
+}
+`
+	if schema := h.Schema(schemaEvent()); schema != wantSchema {
+		t.Errorf("got schema:\n%s", schema)
+	}
+}
+
+func TestKindValue(t *testing.T) {
+	cases := []struct {
+		column *river.Column
+		value  interface{}
+		want   interface{}
+	}{
+		{&river.Column{Type: river.ColumnTypeNumber, RawType: "bigint(20) unsigned", Unsigned: true}, uint64(1 << 63), uint64(1 << 63)},
+		{&river.Column{Type: river.ColumnTypeEnum, RawType: "enum('a','b')"}, int64(2), "b"},
+		{&river.Column{Type: river.ColumnTypeSet, RawType: "set('a','b')"}, int64(3), "a,b"},
+		{&river.Column{Type: river.ColumnTypeString, RawType: "blob"}, []byte{1}, []byte{1}},
+		{&river.Column{Type: river.ColumnTypeString, RawType: "text"}, []byte("x"), "x"},
+		{&river.Column{Type: river.ColumnTypeFloat, RawType: "double"}, float64(1.5), float64(1.5)},
+	}
+	for _, c := range cases {
+		got, err := kindValue(c.column, columnKind(c.column), c.value)
+		if err != nil || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %#v, want %#v, err %v", c.column.RawType, got, c.want, err)
+		}
+	}
+	if _, err := kindValue(nil, kindLong, "x"); err == nil {
+		t.Error("expect error for string as long")
+	}
+}
+
+func TestConfluentRegistry(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Path == "/subjects/conflict-value/versions" {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"error_code":409,"message":"incompatible schema"}`))
+			return
+		}
+		if r.Method != http.MethodPost || r.URL.Path != "/subjects/binlog-value/versions" ||
+			r.Header.Get("Content-Type") != confluentContentType {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+		}
+		if user, password, _ := r.BasicAuth(); user != "user" || password != "secret" {
+			t.Errorf("unexpected auth: %s %s", user, password)
+		}
+		if string(body) != `{"schema":"message Row {}","schemaType":"PROTOBUF"}` {
+			t.Errorf("unexpected body: %s", body)
+		}
+		w.Write([]byte(`{"id":7}`))
+	}))
+	defer server.Close()
+
+	registry := (&Config{
+		SchemaRegistry:         server.URL + "/",
+		SchemaRegistryUser:     "user",
+		SchemaRegistryPassword: "secret",
+	}).schemaRegistry().(*ConfluentRegistry)
+	for i := 0; i < 2; i++ {
+		id, err := registry.Register("binlog-value", SchemaTypeProtobuf, "message Row {}")
+		if err != nil || id != 7 {
+			t.Fatalf("got id %d, err %v", id, err)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("registered schema should be cached, got %d requests", requests)
+	}
+	if _, err := registry.Register("conflict-value", SchemaTypeProtobuf, "message Row {}"); err == nil {
+		t.Error("expect error for conflict")
+	}
+}
+
+// fixedRegistry 总是返回同一个id
+type fixedRegistry struct {
+	subjects []string
+	types    []string
+}
+
+func (r *fixedRegistry) Register(subject, schemaType, schema string) (int, error) {
+	r.subjects = append(r.subjects, subject)
+	r.types = append(r.types, schemaType)
+	return 7, nil
+}
+
+func TestEncodeWithSchema(t *testing.T) {
+	registry := &fixedRegistry{}
+	config := &Config{Topic: "binlog", Format: FormatProtobuf}
+	handler, err := config.brokerHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := handler.(*ProtobufHandler)
+	if h.Registry != nil || h.Subject != nil {
+		t.Fatal("registry should be nil without SchemaRegistry")
+	}
+	config.SchemaRegistry, config.TopicTemplate = "http://127.0.0.1:8081", "binlog.{table}"
+	h.Registry, h.Subject = registry, config.schemaSubject()
+	raw, _ := NewProtobufHandler(defaultServerName, nil).Marshal(schemaEvent())
+	got, err := h.Marshal(schemaEvent())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append([]byte{0, 0, 0, 0, 7, 0}, raw...); !bytes.Equal(got, want) {
+		t.Errorf("protobuf: got %x, want %x", got, want)
+	}
+
+	a := NewAvroHandler(defaultServerName, registry)
+	raw, _ = NewAvroHandler(defaultServerName, nil).Marshal(schemaEvent())
+	if got, err = a.Marshal(schemaEvent()); err != nil {
+		t.Fatal(err)
+	}
+	if want := append([]byte{0, 0, 0, 0, 7}, raw...); !bytes.Equal(got, want) {
+		t.Errorf("avro: got %x, want %x", got, want)
+	}
+	wantSubjects := []string{"binlog.t-value", "mysql_river.shop.t.Envelope"}
+	wantTypes := []string{SchemaTypeProtobuf, SchemaTypeAvro}
+	for i := range wantSubjects {
+		if registry.subjects[i] != wantSubjects[i] || registry.types[i] != wantTypes[i] {
+			t.Errorf("got subjects %v, types %v", registry.subjects, registry.types)
+		}
+	}
+}
+
+func TestConfig_SchemaSubject(t *testing.T) {
+	config := &Config{Topic: "binlog", Format: FormatAvro, SchemaRegistry: "http://127.0.0.1:8081"}
+	handler, err := config.brokerHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 所有表在同一个topic中时使用 Envelope 的全名, 避免不同表的schema注册到同一个subject
+	if h := handler.(*AvroHandler); h.Registry == nil || h.Subject != nil {
+		t.Errorf("single topic should use record name subject, got %+v", h)
+	}
+
+	config.TopicTemplate = "binlog.{db}.{table}"
+	if handler, err = config.brokerHandler(); err != nil {
+		t.Fatal(err)
+	}
+	h := handler.(*AvroHandler)
+	if h.Subject == nil || h.Subject(schemaEvent()) != "binlog.shop.t-value" {
+		t.Errorf("topic template should use topic name subject")
+	}
+}
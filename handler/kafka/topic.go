@@ -0,0 +1,113 @@
+package kafka
+
+import (
+	"fmt"
+	"github.com/Shopify/sarama"
+	"github.com/juju/errors"
+	"github.com/obgnail/mysql-river/river"
+	"strings"
+	"sync"
+)
+
+const (
+	TopicTemplateDb    = "{db}"
+	TopicTemplateTable = "{table}"
+
+	defaultTopicPartitions        = 1
+	defaultTopicReplicationFactor = 1
+)
+
+// TopicName 将 TopicTemplate 中的 {db} 和 {table} 替换为库名和表名, kafka 不支持的字符替换为 _
+func TopicName(template, db, table string) string {
+	topic := strings.NewReplacer(TopicTemplateDb, db, TopicTemplateTable, table).Replace(template)
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, topic)
+}
+
+// controlTopic 接收 ddl、gtid、xid 等event的topic, 默认为 Topic
+func (c *Config) controlTopic() string {
+	if len(c.ControlTopic) != 0 {
+		return c.ControlTopic
+	}
+	return c.Topic
+}
+
+func (c *Config) checkTopic() error {
+	if len(c.TopicTemplate) == 0 {
+		if len(c.Topic) == 0 {
+			return fmt.Errorf("topic is empty")
+		}
+		return nil
+	}
+	if !strings.Contains(c.TopicTemplate, TopicTemplateTable) {
+		return fmt.Errorf("topic template %q has no %s", c.TopicTemplate, TopicTemplateTable)
+	}
+	if len(c.controlTopic()) == 0 {
+		return fmt.Errorf("control topic is empty")
+	}
+	return nil
+}
+
+// topicOf 返回event对应的topic. 没有 TopicTemplate 时所有event发送到 Topic,
+// 否则行变更发送到表对应的topic, 其余event发送到 ControlTopic
+func (c *Config) topicOf(event *river.EventData) string {
+	if len(c.TopicTemplate) == 0 {
+		return c.Topic
+	}
+	switch event.EventType {
+	case river.EventTypeInsert, river.EventTypeUpdate, river.EventTypeDelete:
+		return TopicName(c.TopicTemplate, event.Db, event.Table)
+	}
+	return c.controlTopic()
+}
+
+// topicCreator 在第一次发送消息前创建topic, topic已存在时忽略
+type topicCreator struct {
+	admin  sarama.ClusterAdmin
+	detail *sarama.TopicDetail
+
+	sync.Mutex
+	created map[string]struct{}
+}
+
+func newTopicCreator(addrs []string, cfg *sarama.Config, config *Config) (*topicCreator, error) {
+	admin, err := sarama.NewClusterAdmin(addrs, cfg)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	detail := &sarama.TopicDetail{
+		NumPartitions:     config.TopicPartitions,
+		ReplicationFactor: config.TopicReplicationFactor,
+	}
+	if detail.NumPartitions <= 0 {
+		detail.NumPartitions = defaultTopicPartitions
+	}
+	if detail.ReplicationFactor <= 0 {
+		detail.ReplicationFactor = defaultTopicReplicationFactor
+	}
+	return &topicCreator{admin: admin, detail: detail, created: make(map[string]struct{})}, nil
+}
+
+func (c *topicCreator) create(topic string) error {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.created[topic]; ok {
+		return nil
+	}
+	if err := c.admin.CreateTopic(topic, c.detail, false); err != nil {
+		if topicErr, ok := err.(*sarama.TopicError); !ok || topicErr.Err != sarama.ErrTopicAlreadyExists {
+			return errors.Annotatef(err, "create topic %s", topic)
+		}
+	}
+	river.Logger.Infof("kafka topic %s is ready", topic)
+	c.created[topic] = struct{}{}
+	return nil
+}
+
+func (c *topicCreator) close() error {
+	return errors.Trace(c.admin.Close())
+}
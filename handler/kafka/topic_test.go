@@ -0,0 +1,49 @@
+package kafka
+
+import (
+	"github.com/obgnail/mysql-river/river"
+	"testing"
+)
+
+func TestConfig_TopicOf(t *testing.T) {
+	insert := &river.EventData{EventType: river.EventTypeInsert, Db: "shop", Table: "order$item"}
+	ddl := &river.EventData{EventType: river.EventTypeDDL, Db: "shop", Table: "order"}
+	xid := &river.EventData{EventType: river.EventTypeXID}
+
+	config := &Config{Topic: "binlog"}
+	for _, event := range []*river.EventData{insert, ddl, xid} {
+		if got := config.topicOf(event); got != "binlog" {
+			t.Errorf("got %s, want binlog", got)
+		}
+	}
+
+	config = &Config{Topic: "binlog", TopicTemplate: "binlog.{db}.{table}"}
+	if got := config.topicOf(insert); got != "binlog.shop.order_item" {
+		t.Errorf("got %s, want binlog.shop.order_item", got)
+	}
+	if got := config.topicOf(ddl); got != "binlog" {
+		t.Errorf("got %s, want binlog", got)
+	}
+	config.ControlTopic = "binlog.control"
+	if got := config.topicOf(xid); got != "binlog.control" {
+		t.Errorf("got %s, want binlog.control", got)
+	}
+}
+
+func TestConfig_CheckTopic(t *testing.T) {
+	cases := []struct {
+		config *Config
+		valid  bool
+	}{
+		{&Config{Topic: "binlog"}, true},
+		{&Config{}, false},
+		{&Config{TopicTemplate: "binlog.{db}.{table}", ControlTopic: "binlog.control"}, true},
+		{&Config{TopicTemplate: "binlog.{db}.{table}"}, false},
+		{&Config{TopicTemplate: "binlog.{db}", Topic: "binlog"}, false},
+	}
+	for _, c := range cases {
+		if err := c.config.checkTopic(); (err == nil) != c.valid {
+			t.Errorf("%+v: got %v, want valid %t", c.config, err, c.valid)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package kafka
+
+import (
+	"fmt"
+	"github.com/Shopify/sarama"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/juju/errors"
+	"github.com/obgnail/mysql-river/river"
+	"time"
+)
+
+const (
+	defaultTransactionalID = "mysql-river"
+	positionTopicSuffix    = ".position"
+
+	positionLookback    = 100         // 读取最后提交的位置时, 每个分区最多向前读取的消息数
+	positionReadTimeout = time.Second // 读取位置时超过该时间没有新消息则认为已读完
+)
+
+// transactionalID 同一时间只能有一个生产者使用, 新的生产者会使旧的生产者失效并中止其未提交的事务
+func (c *Config) transactionalID() string {
+	if len(c.TransactionalID) != 0 {
+		return c.TransactionalID
+	}
+	return defaultTransactionalID
+}
+
+// positionTopic 事务生产者保存binlog位置的topic, 默认为 {ControlTopic}.position
+func (c *Config) positionTopic() string {
+	if len(c.PositionTopic) != 0 {
+		return c.PositionTopic
+	}
+	return c.controlTopic() + positionTopicSuffix
+}
+
+func (c *Config) checkTransactional() error {
+	if c.Transactional && c.Async {
+		return fmt.Errorf("transactional producer can not be async")
+	}
+	return nil
+}
+
+// txnProducer 幂等的事务生产者. 一个mysql事务的消息和事务提交的位置在一个kafka事务中发送,
+// 发送或提交失败时中止事务, read_committed 的消费者不会读到部分或重复的事务.
+// 没有消息的event不开启事务, 其位置和之后的事务一起提交
+type txnProducer struct {
+	producer      sarama.SyncProducer
+	positionTopic string
+	key           []byte // 位置消息的key, 为 TransactionalID
+}
+
+var _ producer = (*txnProducer)(nil)
+
+func (p *txnProducer) send(pos mysql.Position, msg *sarama.ProducerMessage) error {
+	if msg == nil {
+		return nil
+	}
+	return errors.Trace(p.sendTxn(pos, []*sarama.ProducerMessage{msg}))
+}
+
+func (p *txnProducer) sendTxn(pos mysql.Position, msgs []*sarama.ProducerMessage) error {
+	if err := p.producer.BeginTxn(); err != nil {
+		return errors.Trace(err)
+	}
+	msgs = append(msgs, &sarama.ProducerMessage{
+		Topic: p.positionTopic,
+		Key:   sarama.ByteEncoder(p.key),
+		Value: sarama.StringEncoder(fmt.Sprintf("%s:%d", pos.Name, pos.Pos)),
+	})
+	if err := p.producer.SendMessages(msgs); err != nil {
+		p.abort(pos)
+		return errors.Annotatef(err, "send kafka transaction of position %s", pos)
+	}
+	// 提交失败后生产者处于需要中止的状态, 不中止则之后的 BeginTxn 都会失败
+	if err := p.producer.CommitTxn(); err != nil {
+		p.abort(pos)
+		return errors.Annotatef(err, "commit kafka transaction of position %s", pos)
+	}
+	return nil
+}
+
+func (p *txnProducer) abort(pos mysql.Position) {
+	if err := p.producer.AbortTxn(); err != nil {
+		river.Logger.Errorf("abort kafka transaction of position %s error: %s", pos, err)
+	}
+}
+
+func (p *txnProducer) committed(handled mysql.Position) mysql.Position {
+	return handled
+}
+
+func (p *txnProducer) inFlight() int {
+	return 0
+}
+
+func (p *txnProducer) close() error {
+	return errors.Trace(p.producer.Close())
+}
+
+// eventBatch 事务生产者使用的 BatchMarshaler, BrokerHandler 没有实现 BatchMarshaler 时逐个生成event的消息.
+// gtid、rotate 等event和行变更一起缓存到 xid 或 ddl, 在同一个kafka事务中发送, 不为每个event单独开启事务
+type eventBatch struct {
+	broker *Broker
+}
+
+func (m eventBatch) MarshalBatch(events []*river.EventData) ([]*Message, error) {
+	var msgs []*Message
+	for _, event := range events {
+		key, value, err := m.broker.marshal(event)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if len(value) != 0 {
+			msgs = append(msgs, &Message{Event: event, Key: key, Value: value})
+		}
+	}
+	return msgs, nil
+}
+
+// Position 返回 PositionTopic 中最后提交的位置, 没有时返回空的位置
+func (b *Broker) Position() (mysql.Position, error) {
+	topic := b.config.positionTopic()
+	client, err := sarama.NewClient(b.config.Addrs, b.saramaConfig)
+	if err != nil {
+		return mysql.Position{}, errors.Trace(err)
+	}
+	defer client.Close()
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return mysql.Position{}, errors.Trace(err)
+	}
+	defer consumer.Close()
+
+	pos, err := lastPosition(consumer, topic, func(partition int32) (oldest, newest int64, err error) {
+		if oldest, err = client.GetOffset(topic, partition, sarama.OffsetOldest); err != nil {
+			return 0, 0, errors.Trace(err)
+		}
+		newest, err = client.GetOffset(topic, partition, sarama.OffsetNewest)
+		return oldest, newest, errors.Trace(err)
+	})
+	if errors.Cause(err) == sarama.ErrUnknownTopicOrPartition {
+		return mysql.Position{}, nil
+	}
+	return pos, errors.Trace(err)
+}
+
+// lastPosition 返回topic所有分区中最后提交的位置中最大的一个
+func lastPosition(consumer sarama.Consumer, topic string,
+	getOffsets func(partition int32) (oldest, newest int64, err error)) (mysql.Position, error) {
+	partitions, err := consumer.Partitions(topic)
+	if err != nil {
+		return mysql.Position{}, errors.Trace(err)
+	}
+	var last mysql.Position
+	for _, partition := range partitions {
+		oldest, newest, err := getOffsets(partition)
+		if err != nil {
+			return mysql.Position{}, errors.Trace(err)
+		}
+		if newest <= oldest {
+			continue
+		}
+		start := newest - positionLookback
+		if start < oldest {
+			start = oldest
+		}
+		pos, err := lastPartitionPosition(consumer, topic, partition, start, newest)
+		if err != nil {
+			return mysql.Position{}, errors.Annotatef(err, "partition %d", partition)
+		}
+		if len(pos.Name) != 0 && (len(last.Name) == 0 || pos.Compare(last) > 0) {
+			last = pos
+		}
+	}
+	return last, nil
+}
+
+// lastPartitionPosition 读取分区中 [start, newest) 的位置消息. 每个事务的位置消息之后是事务的提交标记,
+// 读到 newest-2 时说明已经读到最后一个事务; 最后一个事务被中止时, 等待 positionReadTimeout 后返回
+func lastPartitionPosition(consumer sarama.Consumer, topic string, partition int32, start, newest int64) (mysql.Position, error) {
+	pc, err := consumer.ConsumePartition(topic, partition, start)
+	if err != nil {
+		return mysql.Position{}, errors.Trace(err)
+	}
+	defer pc.Close()
+
+	var pos mysql.Position
+	for {
+		select {
+		case msg := <-pc.Messages():
+			if pos, err = river.ParsePosition(string(msg.Value)); err != nil {
+				return mysql.Position{}, errors.Annotatef(err, "offset %d", msg.Offset)
+			}
+			if msg.Offset >= newest-2 {
+				return pos, nil
+			}
+		case e := <-pc.Errors():
+			return mysql.Position{}, errors.Trace(e)
+		case <-time.After(positionReadTimeout):
+			return pos, nil
+		}
+	}
+}
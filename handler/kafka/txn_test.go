@@ -0,0 +1,206 @@
+package kafka
+
+import (
+	"fmt"
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"testing"
+)
+
+func newTxnBroker(t *testing.T, config *Config) (*Broker, *mocks.SyncProducer) {
+	cfg, err := NewSaramaConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Producer.Idempotent || cfg.Net.MaxOpenRequests != 1 || cfg.Consumer.IsolationLevel != sarama.ReadCommitted {
+		t.Fatalf("unexpected transactional config: %+v", cfg)
+	}
+	mock := mocks.NewSyncProducer(t, cfg)
+	p := &txnProducer{producer: mock, positionTopic: config.positionTopic(), key: []byte(config.transactionalID())}
+	return &Broker{config: config, producer: p, batch: &batch{}, BrokerHandler: &DefaultHandler{}}, mock
+}
+
+func TestBroker_OnEventTransactional(t *testing.T) {
+	b, mock := newTxnBroker(t, &Config{Topic: "binlog", Transactional: true})
+	var topics, values []string
+	record := func(msg *sarama.ProducerMessage) error {
+		if mock.TxnStatus()&sarama.ProducerTxnFlagInTransaction == 0 {
+			return fmt.Errorf("message sent outside transaction")
+		}
+		value, _ := msg.Value.Encode()
+		topics = append(topics, msg.Topic)
+		values = append(values, string(value))
+		return nil
+	}
+	// gtid、3个行变更和 xid 在一个事务中, 最后是位置消息
+	for i := 0; i < 6; i++ {
+		mock.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(record)
+	}
+
+	events := txEvents()
+	for i, event := range events {
+		if err := b.OnEvent(event); err != nil {
+			t.Fatal(err)
+		}
+		handled := mysql.Position{Name: event.LogName, Pos: event.LogPos}
+		want := handled
+		if i < len(events)-1 {
+			want = mysql.Position{} // 事务提交前还没有可以保存的位置
+		}
+		if got := b.Committed(handled); got != want {
+			t.Errorf("event %d: committed %s, want %s", i, got, want)
+		}
+	}
+	wantTopics := []string{"binlog", "binlog", "binlog", "binlog", "binlog", "binlog.position"}
+	if fmt.Sprint(topics) != fmt.Sprint(wantTopics) || values[5] != "mysql-bin.000002:500" {
+		t.Errorf("got topics %v, values %v", topics, values)
+	}
+	if mock.TxnStatus() != sarama.ProducerTxnFlagReady {
+		t.Errorf("transaction should be committed, got status %v", mock.TxnStatus())
+	}
+	if err := mock.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBroker_OnEventTransactionalAbort(t *testing.T) {
+	b, mock := newTxnBroker(t, &Config{Topic: "binlog", ControlTopic: "control", TopicTemplate: "binlog.{table}",
+		Transactional: true, TransactionalID: "river-1"})
+	if b.config.positionTopic() != "control.position" {
+		t.Errorf("got position topic %s", b.config.positionTopic())
+	}
+	events := txEvents()
+	xid := events[len(events)-1]
+
+	// 事务发送失败: gtid、3个行变更、xid 和位置消息
+	mock.ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+	for i := 0; i < 5; i++ {
+		mock.ExpectSendMessageAndSucceed()
+	}
+	for _, event := range events[:len(events)-1] {
+		if err := b.OnEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.OnEvent(xid); err == nil {
+		t.Fatal("expect error when sending transaction")
+	}
+	if mock.TxnStatus() != sarama.ProducerTxnFlagReady {
+		t.Errorf("transaction should be aborted, got status %v", mock.TxnStatus())
+	}
+
+	// ErrorPolicy 重试 xid, 新的事务仍然包含所有行变更
+	var topics, values []string
+	record := func(msg *sarama.ProducerMessage) error {
+		value, _ := msg.Value.Encode()
+		topics = append(topics, msg.Topic)
+		values = append(values, string(value))
+		return nil
+	}
+	for i := 0; i < 6; i++ {
+		mock.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(record)
+	}
+	if err := b.OnEvent(xid); err != nil {
+		t.Fatal(err)
+	}
+	wantTopics := []string{"control", "binlog.order", "binlog.order", "binlog.order", "control", "control.position"}
+	if fmt.Sprint(topics) != fmt.Sprint(wantTopics) || values[5] != "mysql-bin.000002:500" {
+		t.Errorf("got topics %v, values %v", topics, values)
+	}
+	if mock.TxnStatus() != sarama.ProducerTxnFlagReady {
+		t.Errorf("transaction should be committed, got status %v", mock.TxnStatus())
+	}
+	if err := mock.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+// commitFailProducer 前 fail 次提交失败. 和 sarama 一样, 提交失败后没有中止事务时不能开始新的事务
+type commitFailProducer struct {
+	*mocks.SyncProducer
+	fail int
+}
+
+func (p *commitFailProducer) BeginTxn() error {
+	if p.TxnStatus()&sarama.ProducerTxnFlagInTransaction != 0 {
+		return fmt.Errorf("transaction is not ready")
+	}
+	return p.SyncProducer.BeginTxn()
+}
+
+func (p *commitFailProducer) CommitTxn() error {
+	if p.fail > 0 {
+		p.fail--
+		return fmt.Errorf("commit failed")
+	}
+	return p.SyncProducer.CommitTxn()
+}
+
+func TestBroker_OnEventTransactionalCommitFail(t *testing.T) {
+	b, mock := newTxnBroker(t, &Config{Topic: "binlog", Transactional: true})
+	b.producer.(*txnProducer).producer = &commitFailProducer{SyncProducer: mock, fail: 1}
+	for i := 0; i < 12; i++ {
+		mock.ExpectSendMessageAndSucceed()
+	}
+	events := txEvents()
+	xid := events[len(events)-1]
+	for _, event := range events[:len(events)-1] {
+		if err := b.OnEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.OnEvent(xid); err == nil {
+		t.Fatal("expect error when committing transaction")
+	}
+	if mock.TxnStatus() != sarama.ProducerTxnFlagReady {
+		t.Errorf("transaction should be aborted, got status %v", mock.TxnStatus())
+	}
+	// 中止后重试可以开始新的事务
+	if err := b.OnEvent(xid); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestConfig_CheckTransactional(t *testing.T) {
+	if err := (&Config{Transactional: true, Async: true}).checkTransactional(); err == nil {
+		t.Error("expect error for async transactional producer")
+	}
+	if _, err := NewSaramaConfig(&Config{Transactional: true, MaxRetries: -1}); err == nil {
+		t.Error("expect error for idempotent producer without retry")
+	}
+	cfg, err := NewSaramaConfig(&Config{ReadCommitted: true})
+	if err != nil || cfg.Consumer.IsolationLevel != sarama.ReadCommitted || cfg.Producer.Idempotent {
+		t.Errorf("read committed consumer: %+v, err %v", cfg, err)
+	}
+}
+
+func TestLastPosition(t *testing.T) {
+	topic := "binlog.position"
+	consumer := mocks.NewConsumer(t, nil)
+	consumer.SetTopicMetadata(map[string][]int32{topic: {0, 1, 2}})
+	consumer.ExpectConsumePartition(topic, 0, 0).
+		YieldMessage(&sarama.ConsumerMessage{Value: []byte("mysql-bin.000001:100")}).
+		YieldMessage(&sarama.ConsumerMessage{Value: []byte("mysql-bin.000001:200")}).
+		YieldMessage(&sarama.ConsumerMessage{Value: []byte("mysql-bin.000002:4")})
+	// 最后一个事务被中止, 读不到 newest-2 的消息
+	consumer.ExpectConsumePartition(topic, 1, 300).
+		YieldMessage(&sarama.ConsumerMessage{Value: []byte("mysql-bin.000001:900")})
+	offsets := map[int32][2]int64{0: {0, 4}, 1: {200, 400}, 2: {7, 7}}
+
+	pos, err := lastPosition(consumer, topic, func(partition int32) (int64, int64, error) {
+		return offsets[partition][0], offsets[partition][1], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (mysql.Position{Name: "mysql-bin.000002", Pos: 4}); pos != want {
+		t.Errorf("got %s, want %s", pos, want)
+	}
+	if err := consumer.Close(); err != nil {
+		t.Error(err)
+	}
+}
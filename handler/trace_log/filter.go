@@ -0,0 +1,141 @@
+package trace_log
+
+import (
+	"fmt"
+	"github.com/obgnail/mysql-river/river"
+	"path"
+	"strings"
+)
+
+const RedactedValue = "******"
+
+// filter 按库、表过滤event并对敏感字段脱敏, 库名、表名和字段名均不区分大小写
+type filter struct {
+	dbs      map[string]struct{} // map[db]struct{}
+	tables   [][2]string         // [db, table]
+	excludes [][2]string         // [db, table]
+	redacts  [][3]string         // [db, table, column]
+}
+
+func newFilter(config *Config) (*filter, error) {
+	f := &filter{dbs: list2map(config.DBs)}
+	var err error
+	if f.tables, err = parseTablePatterns(config.Tables); err != nil {
+		return nil, err
+	}
+	if f.excludes, err = parseTablePatterns(config.ExcludeTables); err != nil {
+		return nil, err
+	}
+	for _, pattern := range config.RedactColumns {
+		p := [3]string{"*", "*", strings.ToLower(pattern)}
+		if parts := strings.Split(p[2], "."); len(parts) == 3 {
+			copy(p[:], parts)
+		} else if len(parts) != 1 {
+			return nil, fmt.Errorf("invalid redact column %q, expect column or db.table.column", pattern)
+		}
+		for _, s := range p {
+			if _, err := path.Match(s, ""); err != nil {
+				return nil, fmt.Errorf("invalid redact column %q: %s", pattern, err)
+			}
+		}
+		f.redacts = append(f.redacts, p)
+	}
+	return f, nil
+}
+
+// parseTablePatterns 解析 db.table 格式的规则, 省略表名时等同于 db.*
+func parseTablePatterns(patterns []string) ([][2]string, error) {
+	var res [][2]string
+	for _, pattern := range patterns {
+		p := [2]string{strings.ToLower(pattern), "*"}
+		if idx := strings.Index(p[0], "."); idx >= 0 {
+			p[0], p[1] = p[0][:idx], p[0][idx+1:]
+		}
+		for _, s := range p {
+			if _, err := path.Match(s, ""); err != nil {
+				return nil, fmt.Errorf("invalid table pattern %q: %s", pattern, err)
+			}
+		}
+		res = append(res, p)
+	}
+	return res, nil
+}
+
+// matchTable 表名为空(ddl)时只匹配库名
+func matchTable(patterns [][2]string, db, table string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p[0], db); !ok {
+			continue
+		}
+		if len(table) == 0 {
+			return true
+		}
+		if ok, _ := path.Match(p[1], table); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// skip gtid、xid 等不属于任何库的event不会被过滤
+func (f *filter) skip(event *river.EventData) bool {
+	if len(event.Db) == 0 {
+		return false
+	}
+	db, table := strings.ToLower(event.Db), strings.ToLower(event.Table)
+	if len(f.dbs) != 0 {
+		if _, ok := f.dbs[db]; !ok {
+			return true
+		}
+	}
+	if len(f.tables) != 0 && !matchTable(f.tables, db, table) {
+		return true
+	}
+	// 排除规则只作用于表, 不过滤库级别的ddl
+	return len(table) != 0 && matchTable(f.excludes, db, table)
+}
+
+func (f *filter) needRedact(db, table, column string) bool {
+	for _, p := range f.redacts {
+		if ok, _ := path.Match(p[0], db); !ok {
+			continue
+		}
+		if ok, _ := path.Match(p[1], table); !ok {
+			continue
+		}
+		if ok, _ := path.Match(p[2], column); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// redact 返回敏感字段的值替换为 RedactedValue 的event副本, NULL 保持不变
+func (f *filter) redact(event *river.EventData) *river.EventData {
+	if len(f.redacts) == 0 {
+		return event
+	}
+	db, table := strings.ToLower(event.Db), strings.ToLower(event.Table)
+	mask := func(kv map[string]interface{}) map[string]interface{} {
+		res := make(map[string]interface{}, len(kv))
+		for field, value := range kv {
+			if value != nil && f.needRedact(db, table, strings.ToLower(field)) {
+				value = RedactedValue
+			}
+			res[field] = value
+		}
+		return res
+	}
+	redacted := *event
+	redacted.Before = mask(event.Before)
+	redacted.After = mask(event.After)
+	// 脱敏后的值按字符串输出
+	redacted.Columns = make([]*river.Column, 0, len(event.Columns))
+	for _, c := range event.Columns {
+		if f.needRedact(db, table, strings.ToLower(c.Name)) {
+			c = &river.Column{Name: c.Name, Type: river.ColumnTypeString, RawType: "varchar"}
+		}
+		redacted.Columns = append(redacted.Columns, c)
+	}
+	return &redacted
+}
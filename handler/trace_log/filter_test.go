@@ -0,0 +1,65 @@
+package trace_log
+
+import (
+	"github.com/obgnail/mysql-river/river"
+	"testing"
+)
+
+func TestFilter_Skip(t *testing.T) {
+	f, err := newFilter(&Config{
+		DBs:           []string{"TestDB", "orders"},
+		Tables:        []string{"testdb.user*", "orders"},
+		ExcludeTables: []string{"*.user_log"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		db, table string
+		skip      bool
+	}{
+		{"testdb", "user", false},
+		{"TESTDB", "User", false},
+		{"testdb", "user_log", true},
+		{"testdb", "order", true},
+		{"testdb", "", false},
+		{"orders", "item", false},
+		{"other", "user", true},
+		{"", "", false},
+	}
+	for _, c := range cases {
+		event := &river.EventData{Db: c.db, Table: c.table}
+		if got := f.skip(event); got != c.skip {
+			t.Errorf("%s.%s: got %v, want %v", c.db, c.table, got, c.skip)
+		}
+	}
+
+	if _, err := newFilter(&Config{Tables: []string{"db.[a"}}); err == nil {
+		t.Error("expect error for invalid pattern")
+	}
+	if _, err := newFilter(&Config{RedactColumns: []string{"db.password"}}); err == nil {
+		t.Error("expect error for invalid redact column")
+	}
+}
+
+func TestTraceLogHandler_Redact(t *testing.T) {
+	event := &river.EventData{
+		EventType: river.EventTypeInsert,
+		Db:        "db",
+		Table:     "user",
+		Columns: []*river.Column{
+			{Name: "id", Type: river.ColumnTypeNumber},
+			{Name: "Password", Type: river.ColumnTypeBinary, RawType: "varbinary(64)"},
+			{Name: "phone", Type: river.ColumnTypeString, RawType: "varchar(16)"},
+			{Name: "email", Type: river.ColumnTypeString, RawType: "varchar(64)"},
+		},
+		After: map[string]interface{}{"id": 1, "Password": "\x01\x02", "phone": nil, "email": "a@b.c"},
+	}
+	want := "INSERT INTO `db`.`user`(`id`, `Password`, `phone`, `email`) VALUES (1, '******', NULL, 'a@b.c');\n"
+	if got := mustFormat(t, &Config{RedactColumns: []string{"password", "db.user.phone"}}, event); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if event.After["Password"] != "\x01\x02" {
+		t.Error("redact should not modify the original event")
+	}
+}
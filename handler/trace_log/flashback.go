@@ -0,0 +1,113 @@
+package trace_log
+
+import (
+	"fmt"
+	"github.com/juju/errors"
+	"github.com/obgnail/mysql-river/river"
+	"sync"
+	"time"
+)
+
+// FlashbackConfig 闪回模式: 为范围内的行变更生成逆向sql(insert->delete, delete->insert, update前后值互换),
+// 并按照与原始变更相反的顺序输出, 用于回滚误操作. 范围的边界为空时不限制.
+// 超出 StopPos 或 StopTime 后输出闪回sql并停止river, 未设置时在river关闭时输出.
+type FlashbackConfig struct {
+	StartPos  string    `toml:"start_pos"` // mysql-bin.000001:4
+	StopPos   string    `toml:"stop_pos"`
+	StartTime time.Time `toml:"start_time"`
+	StopTime  time.Time `toml:"stop_time"`
+}
+
+type flashback struct {
+	sync.Mutex // OnEvent 和 OnClose 可能在不同的协程中调用
+	*eventRange
+
+	sqls []string // 按原始变更顺序保存的逆向sql
+	done bool
+}
+
+func newFlashback(config *FlashbackConfig) (*flashback, error) {
+	r, err := newEventRange(config.StartPos, config.StopPos, config.StartTime, config.StopTime)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &flashback{eventRange: r}, nil
+}
+
+func (f *flashback) add(sql string) {
+	f.sqls = append(f.sqls, sql)
+}
+
+// reversed 返回倒序的逆向sql, 只返回一次
+func (f *flashback) reversed() []string {
+	if f.done {
+		return nil
+	}
+	f.done = true
+	res := make([]string, 0, len(f.sqls))
+	for i := len(f.sqls) - 1; i >= 0; i-- {
+		res = append(res, f.sqls[i])
+	}
+	f.sqls = nil
+	return res
+}
+
+// GenFlashbackSql 生成行变更的逆向sql
+func GenFlashbackSql(event *river.EventData, highlight bool, showAllField bool) string {
+	reversed := *event
+	reversed.Before, reversed.After = event.After, event.Before
+	switch event.EventType {
+	case river.EventTypeInsert:
+		return GenDeleteSql(&reversed, highlight)
+	case river.EventTypeDelete:
+		return GenInsertSql(&reversed, highlight)
+	case river.EventTypeUpdate:
+		return GenUpdateSql(&reversed, highlight, showAllField)
+	}
+	return ""
+}
+
+func (t *TraceLogHandler) onFlashbackEvent(event *river.EventData) error {
+	f := t.flashback
+	f.Lock()
+	defer f.Unlock()
+	if f.done {
+		return river.ErrStop
+	}
+	if f.afterStop(event) {
+		if err := t.printFlashback(); err != nil {
+			return errors.Trace(err)
+		}
+		return river.ErrStop
+	}
+	if f.beforeStart(event) {
+		return nil
+	}
+
+	if t.skip(event) {
+		return nil
+	}
+	switch event.EventType {
+	case river.EventTypeUpdate, river.EventTypeInsert, river.EventTypeDelete:
+		f.add(GenFlashbackSql(event, t.config.Highlight, t.config.EntireFields))
+	case river.EventTypeDDL:
+		f.add(fmt.Sprintf("/* DDL at %s can not be flashback: %s */", event.Position(), event.SQL))
+	}
+	return nil
+}
+
+func (t *TraceLogHandler) flushFlashback() error {
+	t.flashback.Lock()
+	defer t.flashback.Unlock()
+	return errors.Trace(t.printFlashback())
+}
+
+// printFlashback 闪回sql需要按顺序整体执行, 因此即使按库拆分文件也只写入默认文件
+func (t *TraceLogHandler) printFlashback() error {
+	for _, sql := range t.flashback.reversed() {
+		if err := t.output.write("", sql); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
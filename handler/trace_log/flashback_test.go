@@ -0,0 +1,62 @@
+package trace_log
+
+import (
+	"github.com/obgnail/mysql-river/river"
+	"reflect"
+	"testing"
+)
+
+func TestGenFlashbackSql(t *testing.T) {
+	cases := []struct {
+		event *river.EventData
+		want  string
+	}{
+		{
+			event: &river.EventData{EventType: river.EventTypeInsert, Db: "db", Table: "user",
+				After: map[string]interface{}{"id": 1}},
+			want: "DELETE FROM `db`.`user` WHERE `id`=1 LIMIT 1;",
+		},
+		{
+			event: &river.EventData{EventType: river.EventTypeDelete, Db: "db", Table: "user",
+				Before: map[string]interface{}{"id": 1}},
+			want: "INSERT INTO `db`.`user`(`id`) VALUES (1);",
+		},
+		{
+			event: &river.EventData{EventType: river.EventTypeUpdate, Db: "db", Table: "user",
+				Before: map[string]interface{}{"id": 1}, After: map[string]interface{}{"id": 2}},
+			want: "UPDATE `db`.`user` SET `id`=1 WHERE `id`=2 LIMIT 1;",
+		},
+	}
+	for _, c := range cases {
+		if got := GenFlashbackSql(c.event, false, false); got != c.want {
+			t.Errorf("%s: got %s, want %s", c.event.EventType, got, c.want)
+		}
+	}
+}
+
+func TestTraceLogHandler_Flashback(t *testing.T) {
+	handler := mustNew(t, &Config{Flashback: &FlashbackConfig{
+		StartPos: "mysql-bin.000001:100",
+		StopPos:  "mysql-bin.000001:400",
+	}})
+	insert := func(pos uint32, id int) *river.EventData {
+		return &river.EventData{EventType: river.EventTypeInsert, LogName: "mysql-bin.000001", LogPos: pos,
+			Db: "db", Table: "user", After: map[string]interface{}{"id": id}}
+	}
+
+	for _, event := range []*river.EventData{insert(100, 1), insert(200, 2), insert(300, 3)} {
+		if err := handler.OnEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{
+		"DELETE FROM `db`.`user` WHERE `id`=3 LIMIT 1;",
+		"DELETE FROM `db`.`user` WHERE `id`=2 LIMIT 1;",
+	}
+	if got := handler.flashback.reversed(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if err := handler.OnEvent(insert(500, 5)); !river.IsStop(err) {
+		t.Fatalf("expect stop after stop position, got %v", err)
+	}
+}
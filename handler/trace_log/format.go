@@ -0,0 +1,183 @@
+package trace_log
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/juju/errors"
+	"github.com/obgnail/mysql-river/river"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	FormatSQL    = "sql"    // 可以直接执行的sql
+	FormatJSON   = "json"   // 每行一个json对象, 包含位置、gtid和字段变更
+	FormatBinlog = "binlog" // 类似mysqlbinlog的输出, 每条sql前带有 # at 位置和时间注释
+)
+
+func validFormat(format string) bool {
+	switch format {
+	case FormatSQL, FormatJSON, FormatBinlog:
+		return true
+	}
+	return false
+}
+
+// format 根据 Config.Format 格式化event, 返回空字符串表示不输出
+func (t *TraceLogHandler) format(event *river.EventData) (string, error) {
+	switch t.config.Format {
+	case FormatJSON:
+		return t.formatJSON(event)
+	case FormatBinlog:
+		return t.formatBinlog(event), nil
+	}
+	return t.formatSQL(event), nil
+}
+
+func (t *TraceLogHandler) formatSQL(event *river.EventData) string {
+	var data string
+
+	switch event.EventType {
+	case river.EventTypeUpdate, river.EventTypeInsert, river.EventTypeDelete:
+		data = t.handlerRow(event)
+	case river.EventTypeGTID:
+		if t.config.ShowTxMsg {
+			data = fmt.Sprintf("/* GTID: %s */", event.GTIDSet)
+		}
+	case river.EventTypeXID:
+		if t.config.ShowTxMsg {
+			data = fmt.Sprintf("/* XID: %s */", event.Position())
+		}
+	case river.EventTypeDDL:
+		if !t.skip(event) {
+			data = event.SQL
+		}
+	}
+	return data
+}
+
+// formatBinlog 在sql前加上位置和时间注释. river只记录event的结束位置, 因此 # at 为 end_log_pos
+func (t *TraceLogHandler) formatBinlog(event *river.EventData) string {
+	data := t.formatSQL(event)
+	if len(data) == 0 {
+		return ""
+	}
+	header := fmt.Sprintf("# at %s\n#%s server id %d  end_log_pos %d  %s",
+		event.Position(),
+		time.Unix(int64(event.Timestamp), 0).Format("060102 15:04:05"),
+		event.ServerID,
+		event.LogPos,
+		strings.ToUpper(event.EventType),
+	)
+	if len(event.Table) != 0 {
+		header += fmt.Sprintf("  `%s`.`%s`", escapeIdentifier(event.Db), escapeIdentifier(event.Table))
+	}
+	return header + "\n" + data
+}
+
+// JSONRecord 为json格式输出的一行
+type JSONRecord struct {
+	Type      string                            `json:"type"`
+	Position  string                            `json:"position,omitempty"`
+	GTID      string                            `json:"gtid,omitempty"`
+	Timestamp uint32                            `json:"timestamp"`
+	Db        string                            `json:"db,omitempty"`
+	Table     string                            `json:"table,omitempty"`
+	Primary   map[string]interface{}            `json:"primary,omitempty"` // 主键的值
+	Diff      map[string]map[string]interface{} `json:"diff,omitempty"`    // map[field]{"before": v, "after": v}, insert没有before, delete没有after
+	SQL       string                            `json:"sql,omitempty"`     // 仅ddl有值
+	Rows      []*TableStat                      `json:"rows,omitempty"`    // 仅commit和summary有值, 每个表的变更行数
+}
+
+func (t *TraceLogHandler) formatJSON(event *river.EventData) (string, error) {
+	record := &JSONRecord{
+		Type:      event.EventType,
+		Position:  event.Position(),
+		GTID:      event.GTIDSet,
+		Timestamp: event.Timestamp,
+		Db:        event.Db,
+		Table:     event.Table,
+	}
+	switch event.EventType {
+	case river.EventTypeUpdate, river.EventTypeInsert, river.EventTypeDelete:
+		if t.skip(event) {
+			return "", nil
+		}
+		record.Primary = buildPrimary(event)
+		record.Diff = buildDiff(event, t.config.EntireFields)
+	case river.EventTypeGTID, river.EventTypeXID:
+		if !t.config.ShowTxMsg {
+			return "", nil
+		}
+	case river.EventTypeDDL:
+		if t.skip(event) {
+			return "", nil
+		}
+		record.SQL = event.SQL
+	default:
+		return "", nil
+	}
+
+	b, err := json.Marshal(record)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return string(b), nil
+}
+
+func buildPrimary(event *river.EventData) map[string]interface{} {
+	if len(event.Primary) == 0 {
+		return nil
+	}
+	row := event.After
+	if event.EventType == river.EventTypeDelete {
+		row = event.Before
+	}
+	res := make(map[string]interface{}, len(event.Primary))
+	for _, field := range event.Primary {
+		res[field] = buildJSONValue(row[field], event.Column(field))
+	}
+	return res
+}
+
+// buildDiff update只保留变更的字段, entireFields为true时保留所有字段
+func buildDiff(event *river.EventData, entireFields bool) map[string]map[string]interface{} {
+	res := make(map[string]map[string]interface{})
+	for field, value := range event.Before {
+		res[field] = map[string]interface{}{"before": buildJSONValue(value, event.Column(field))}
+	}
+	for field, value := range event.After {
+		column := event.Column(field)
+		if _, ok := res[field]; !ok {
+			res[field] = make(map[string]interface{})
+		}
+		res[field]["after"] = buildJSONValue(value, column)
+
+		if event.EventType == river.EventTypeUpdate && !entireFields &&
+			buildSqlValue(event.Before[field], column) == buildSqlValue(value, column) {
+			delete(res, field)
+		}
+	}
+	return res
+}
+
+// buildJSONValue 文本字段的值转为字符串, 二进制数据转为[]byte(json中为base64)
+func buildJSONValue(value interface{}, column *river.Column) interface{} {
+	var b []byte
+	switch v := value.(type) {
+	case []byte:
+		b = v
+	case string: // binary、varbinary
+		if column != nil && column.IsBinary() {
+			return []byte(v)
+		}
+		return v
+	default:
+		return value
+	}
+	if column != nil && column.IsBinary() || column == nil && !utf8.Valid(b) {
+		return b
+	}
+	return string(b)
+}
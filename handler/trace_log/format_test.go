@@ -0,0 +1,61 @@
+package trace_log
+
+import (
+	"bytes"
+	"github.com/obgnail/mysql-river/river"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTraceLogHandler_Format(t *testing.T) {
+	event := &river.EventData{
+		EventType: river.EventTypeUpdate,
+		ServerID:  1,
+		LogName:   "mysql-bin.000001",
+		LogPos:    1234,
+		Db:        "db",
+		Table:     "user",
+		GTIDSet:   "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5",
+		Primary:   []string{"id"},
+		Columns: []*river.Column{
+			{Name: "id", Type: river.ColumnTypeNumber},
+			{Name: "name", Type: river.ColumnTypeString, RawType: "text"},
+			{Name: "uuid", Type: river.ColumnTypeBinary, RawType: "binary(2)"},
+		},
+		Before:    map[string]interface{}{"id": 1, "name": []byte("lihua"), "uuid": "\x00\xff"},
+		After:     map[string]interface{}{"id": 1, "name": []byte("lilei"), "uuid": "\x00\xff"},
+		Timestamp: uint32(time.Date(2023, 2, 5, 21, 27, 45, 0, time.Local).Unix()),
+	}
+
+	cases := []struct {
+		format string
+		want   string
+	}{
+		{
+			format: FormatJSON,
+			want: `{"type":"update","position":"mysql-bin.000001:1234","gtid":"3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5",` +
+				`"timestamp":` + strconv.Itoa(int(event.Timestamp)) +
+				`,"db":"db","table":"user","primary":{"id":1},"diff":{"name":{"after":"lilei","before":"lihua"}}}` + "\n",
+		},
+		{
+			format: FormatBinlog,
+			want: "# at mysql-bin.000001:1234\n#230205 21:27:45 server id 1  end_log_pos 1234  UPDATE  `db`.`user`\n" +
+				"UPDATE `db`.`user` SET `name`='lilei' WHERE `id`=1 LIMIT 1;\n",
+		},
+	}
+	for _, c := range cases {
+		if got := mustFormat(t, &Config{Format: c.format}, event); got != c.want {
+			t.Errorf("%s: got %s, want %s", c.format, got, c.want)
+		}
+	}
+}
+
+func mustFormat(t *testing.T, config *Config, event *river.EventData) string {
+	var buf bytes.Buffer
+	config.Writer = &buf
+	if err := mustNew(t, config).OnEvent(event); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
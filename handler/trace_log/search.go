@@ -0,0 +1,166 @@
+package trace_log
+
+import (
+	"fmt"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/juju/errors"
+	"github.com/obgnail/mysql-river/river"
+	"strings"
+	"time"
+)
+
+// SearchConfig 搜索模式: 只输出范围内、匹配 Config.Tables 且满足 PrimaryKey 和 Where 条件的行变更, 用于查找某一行被谁、在何时修改.
+// 变更前或变更后的行满足所有条件即为命中. 超出 StopPos 或 StopTime 后停止river.
+// 需要输出位置和时间时使用 FormatBinlog 或 FormatJSON.
+type SearchConfig struct {
+	StartPos   string    `toml:"start_pos"` // mysql-bin.000001:4
+	StopPos    string    `toml:"stop_pos"`
+	StartTime  time.Time `toml:"start_time"`
+	StopTime   time.Time `toml:"stop_time"`
+	PrimaryKey []string  `toml:"primary_key"` // 主键的值, 联合主键按主键字段的顺序
+	Where      []string  `toml:"where"`       // column=value, 字段名不区分大小写, NULL 匹配空值
+}
+
+type search struct {
+	*eventRange
+	primaryKey []string
+	where      [][2]string // [column, value]
+}
+
+func newSearch(config *SearchConfig) (*search, error) {
+	r, err := newEventRange(config.StartPos, config.StopPos, config.StartTime, config.StopTime)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	s := &search{eventRange: r, primaryKey: config.PrimaryKey}
+	for _, cond := range config.Where {
+		idx := strings.Index(cond, "=")
+		if idx <= 0 {
+			return nil, fmt.Errorf("invalid where condition %q, expect column=value", cond)
+		}
+		s.where = append(s.where, [2]string{strings.TrimSpace(cond[:idx]), strings.TrimSpace(cond[idx+1:])})
+	}
+	return s, nil
+}
+
+func (s *search) match(event *river.EventData) bool {
+	if s.matchRow(event, event.Before) {
+		return true
+	}
+	return s.matchRow(event, event.After)
+}
+
+func (s *search) matchRow(event *river.EventData, row map[string]interface{}) bool {
+	if len(row) == 0 {
+		return false
+	}
+	if len(s.primaryKey) != 0 {
+		if len(s.primaryKey) != len(event.Primary) {
+			return false
+		}
+		for i, field := range event.Primary {
+			if buildMatchValue(row[field]) != s.primaryKey[i] {
+				return false
+			}
+		}
+	}
+	for _, cond := range s.where {
+		value, ok := lookupField(row, cond[0])
+		if !ok || buildMatchValue(value) != cond[1] {
+			return false
+		}
+	}
+	return true
+}
+
+func lookupField(row map[string]interface{}, column string) (interface{}, bool) {
+	if value, ok := row[column]; ok {
+		return value, true
+	}
+	for field, value := range row {
+		if strings.EqualFold(field, column) {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// buildMatchValue 将字段值转为用于比较的字符串
+func buildMatchValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format("2006-01-02 15:04:05.999999")
+	}
+	return fmt.Sprint(value)
+}
+
+func (t *TraceLogHandler) onSearchEvent(event *river.EventData) error {
+	s := t.search
+	if s.afterStop(event) {
+		return river.ErrStop
+	}
+	if s.beforeStart(event) || t.skip(event) {
+		return nil
+	}
+	switch event.EventType {
+	case river.EventTypeUpdate, river.EventTypeInsert, river.EventTypeDelete:
+		if !s.match(event) {
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	data, err := t.format(t.filter.redact(event))
+	if err != nil || len(data) == 0 {
+		return errors.Trace(err)
+	}
+	return errors.Trace(t.output.write(event.Db, data))
+}
+
+// eventRange binlog位置和时间范围, 边界为空时不限制
+type eventRange struct {
+	startPos, stopPos   *mysql.Position
+	startTime, stopTime time.Time
+}
+
+func newEventRange(startPos, stopPos string, startTime, stopTime time.Time) (*eventRange, error) {
+	r := &eventRange{startTime: startTime, stopTime: stopTime}
+	if len(startPos) != 0 {
+		pos, err := river.ParsePosition(startPos)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		r.startPos = &pos
+	}
+	if len(stopPos) != 0 {
+		pos, err := river.ParsePosition(stopPos)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		r.stopPos = &pos
+	}
+	return r, nil
+}
+
+// beforeStart event 是否在范围开始之前
+func (r *eventRange) beforeStart(event *river.EventData) bool {
+	pos := mysql.Position{Name: event.LogName, Pos: event.LogPos}
+	if r.startPos != nil && pos.Compare(*r.startPos) <= 0 {
+		return true
+	}
+	return !r.startTime.IsZero() && int64(event.Timestamp) < r.startTime.Unix()
+}
+
+// afterStop event 是否在范围结束之后
+func (r *eventRange) afterStop(event *river.EventData) bool {
+	pos := mysql.Position{Name: event.LogName, Pos: event.LogPos}
+	if r.stopPos != nil && pos.Compare(*r.stopPos) > 0 {
+		return true
+	}
+	return !r.stopTime.IsZero() && int64(event.Timestamp) > r.stopTime.Unix()
+}
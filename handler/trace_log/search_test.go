@@ -0,0 +1,46 @@
+package trace_log
+
+import (
+	"bytes"
+	"github.com/obgnail/mysql-river/river"
+	"strings"
+	"testing"
+)
+
+func TestTraceLogHandler_Search(t *testing.T) {
+	var buf bytes.Buffer
+	handler := mustNew(t, &Config{
+		Tables: []string{"db.user"},
+		Writer: &buf,
+		Search: &SearchConfig{
+			StopPos:    "mysql-bin.000001:500",
+			PrimaryKey: []string{"1"},
+			Where:      []string{"Name=lihua"},
+		},
+	})
+	update := func(pos uint32, table string, id int, before, after string) *river.EventData {
+		return &river.EventData{EventType: river.EventTypeUpdate, LogName: "mysql-bin.000001", LogPos: pos,
+			Db: "db", Table: table, Primary: []string{"id"},
+			Before: map[string]interface{}{"id": id, "name": []byte(before)},
+			After:  map[string]interface{}{"id": id, "name": []byte(after)}}
+	}
+
+	events := []*river.EventData{
+		update(100, "user", 1, "lihua", "lilei"), // 命中变更前的行
+		update(200, "user", 2, "lihua", "lilei"), // 主键不匹配
+		update(300, "order", 1, "lihua", "lilei"),
+		update(400, "user", 1, "lilei", "hanmeimei"),
+	}
+	for _, event := range events {
+		if err := handler.OnEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := "UPDATE `db`.`user` SET `name`='lilei' WHERE `id`=1 LIMIT 1;"
+	if got := strings.TrimSpace(buf.String()); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if err := handler.OnEvent(update(600, "user", 1, "lihua", "lilei")); !river.IsStop(err) {
+		t.Fatalf("expect stop after stop position, got %v", err)
+	}
+}
@@ -0,0 +1,268 @@
+package trace_log
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/juju/errors"
+	"github.com/obgnail/mysql-river/river"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultSummaryTopN = 10
+
+// RowStat 行变更的数量
+type RowStat struct {
+	Insert int `json:"insert"`
+	Update int `json:"update"`
+	Delete int `json:"delete"`
+}
+
+func (s *RowStat) add(eventType string) {
+	switch eventType {
+	case river.EventTypeInsert:
+		s.Insert++
+	case river.EventTypeUpdate:
+		s.Update++
+	case river.EventTypeDelete:
+		s.Delete++
+	}
+}
+
+func (s *RowStat) Total() int {
+	return s.Insert + s.Update + s.Delete
+}
+
+func (s *RowStat) String() string {
+	return fmt.Sprintf("insert %d, update %d, delete %d", s.Insert, s.Update, s.Delete)
+}
+
+// TableStat 表的行变更数量
+type TableStat struct {
+	Db    string `json:"db"`
+	Table string `json:"table"`
+	RowStat
+}
+
+func (s *TableStat) String() string {
+	return fmt.Sprintf("`%s`.`%s` %d (%s)", escapeIdentifier(s.Db), escapeIdentifier(s.Table), s.Total(), &s.RowStat)
+}
+
+// tableStats 按首次出现的顺序记录每个表的行变更数量
+type tableStats struct {
+	tables []*TableStat
+	index  map[[2]string]*TableStat // map[[db, table]]*TableStat
+}
+
+func newTableStats() *tableStats {
+	return &tableStats{index: make(map[[2]string]*TableStat)}
+}
+
+func (s *tableStats) add(event *river.EventData) {
+	key := [2]string{event.Db, event.Table}
+	stat, ok := s.index[key]
+	if !ok {
+		stat = &TableStat{Db: event.Db, Table: event.Table}
+		s.index[key] = stat
+		s.tables = append(s.tables, stat)
+	}
+	stat.add(event.EventType)
+}
+
+func (s *tableStats) total() *RowStat {
+	res := &RowStat{}
+	for _, stat := range s.tables {
+		res.Insert += stat.Insert
+		res.Update += stat.Update
+		res.Delete += stat.Delete
+	}
+	return res
+}
+
+// top 返回变更行数最多的n个表
+func (s *tableStats) top(n int) []*TableStat {
+	res := make([]*TableStat, len(s.tables))
+	copy(res, s.tables)
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Total() > res[j].Total() })
+	if len(res) > n {
+		res = res[:n]
+	}
+	return res
+}
+
+// transaction 当前事务中有变更的库和表
+type transaction struct {
+	dbs   []string            // 按顺序记录, 用于在每个库的文件中写入 BEGIN 和 COMMIT
+	files map[string]struct{} // 已经写入 BEGIN 的文件, 多个库写入同一个文件时只写入一次
+	stats *tableStats
+}
+
+// beforeTx 在事务的第一行变更前写入 BEGIN, 新事务开始(gtid)或ddl隐式提交时结束未提交的事务
+func (t *TraceLogHandler) beforeTx(event *river.EventData) error {
+	switch event.EventType {
+	case river.EventTypeGTID, river.EventTypeDDL:
+		return errors.Trace(t.commitTx(event))
+	case river.EventTypeUpdate, river.EventTypeInsert, river.EventTypeDelete:
+		if t.skip(event) {
+			return nil
+		}
+		if t.tx == nil {
+			t.tx = &transaction{files: make(map[string]struct{}), stats: newTableStats()}
+		}
+		t.tx.stats.add(event)
+		for _, db := range t.tx.dbs {
+			if db == event.Db {
+				return nil
+			}
+		}
+		t.tx.dbs = append(t.tx.dbs, event.Db)
+		if t.config.Format == FormatJSON {
+			return nil
+		}
+		fileName := t.output.fileName(event.Db)
+		if _, ok := t.tx.files[fileName]; ok {
+			return nil
+		}
+		t.tx.files[fileName] = struct{}{}
+		return errors.Trace(t.output.write(event.Db, "BEGIN;"))
+	}
+	return nil
+}
+
+// afterTx 在xid之后写入 COMMIT
+func (t *TraceLogHandler) afterTx(event *river.EventData) error {
+	if event.EventType == river.EventTypeXID {
+		return errors.Trace(t.commitTx(event))
+	}
+	return nil
+}
+
+// commitTx 在事务涉及的每个库中写入 COMMIT, 并注释提交时间、位置和每个表的变更行数
+func (t *TraceLogHandler) commitTx(event *river.EventData) error {
+	tx := t.tx
+	if tx == nil {
+		return nil
+	}
+	t.tx = nil
+
+	var data string
+	if t.config.Format == FormatJSON {
+		b, err := json.Marshal(&JSONRecord{
+			Type:      "commit",
+			Position:  event.Position(),
+			GTID:      event.GTIDSet,
+			Timestamp: event.Timestamp,
+			Rows:      tx.stats.tables,
+		})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		data = string(b)
+	} else {
+		tables := make([]string, 0, len(tx.stats.tables))
+		for _, stat := range tx.stats.tables {
+			tables = append(tables, stat.String())
+		}
+		total := tx.stats.total()
+		data = fmt.Sprintf("COMMIT; /* %s at %s, affected rows: %d (%s); %s */",
+			time.Unix(int64(event.Timestamp), 0).Format("2006-01-02 15:04:05"),
+			event.Position(),
+			total.Total(),
+			total,
+			strings.Join(tables, "; "),
+		)
+	}
+	return errors.Trace(t.output.writeEach(tx.dbs, data))
+}
+
+// summary 定期输出变更行数最多的表
+type summary struct {
+	sync.Mutex
+	stats *tableStats
+	since time.Time
+
+	done     chan struct{}
+	exited   chan struct{}
+	stopOnce sync.Once
+}
+
+func newSummary() *summary {
+	return &summary{
+		stats:  newTableStats(),
+		since:  time.Now(),
+		done:   make(chan struct{}),
+		exited: make(chan struct{}),
+	}
+}
+
+func (s *summary) add(event *river.EventData) {
+	s.Lock()
+	defer s.Unlock()
+	s.stats.add(event)
+}
+
+// reset 返回当前统计的开始时间和统计数据, 并开始新一轮统计
+func (s *summary) reset() (time.Time, *tableStats) {
+	s.Lock()
+	defer s.Unlock()
+	since, stats := s.since, s.stats
+	s.since, s.stats = time.Now(), newTableStats()
+	return since, stats
+}
+
+// stop 停止定期输出并等待正在进行的输出完成
+func (s *summary) stop() {
+	s.stopOnce.Do(func() { close(s.done) })
+	<-s.exited
+}
+
+func (t *TraceLogHandler) loopSummary() {
+	defer close(t.summary.exited)
+	ticker := time.NewTicker(t.config.SummaryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.summary.done:
+			return
+		case <-ticker.C:
+			if err := t.printSummary(); err != nil {
+				river.Logger.Errorf("print trace log summary error: %s", errors.ErrorStack(err))
+			}
+		}
+	}
+}
+
+func (t *TraceLogHandler) printSummary() error {
+	since, stats := t.summary.reset()
+	if len(stats.tables) == 0 {
+		return nil
+	}
+	now := time.Now()
+	top := stats.top(t.config.SummaryTopN)
+
+	var data string
+	if t.config.Format == FormatJSON {
+		b, err := json.Marshal(&JSONRecord{Type: "summary", Timestamp: uint32(now.Unix()), Rows: top})
+		if err != nil {
+			return errors.Trace(err)
+		}
+		data = string(b)
+	} else {
+		tables := make([]string, 0, len(top))
+		for _, stat := range top {
+			tables = append(tables, stat.String())
+		}
+		total := stats.total()
+		data = fmt.Sprintf("/* summary from %s to %s, affected rows: %d (%s), top %d tables: %s */",
+			since.Format("2006-01-02 15:04:05"),
+			now.Format("2006-01-02 15:04:05"),
+			total.Total(),
+			total,
+			len(top),
+			strings.Join(tables, "; "),
+		)
+	}
+	return errors.Trace(t.output.write("", data))
+}
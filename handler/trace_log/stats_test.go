@@ -0,0 +1,104 @@
+package trace_log
+
+import (
+	"bytes"
+	"github.com/obgnail/mysql-river/river"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTraceLogHandler_Transaction(t *testing.T) {
+	ts := uint32(time.Date(2023, 2, 5, 21, 27, 45, 0, time.Local).Unix())
+	row := func(eventType, db, table string, id int) *river.EventData {
+		event := &river.EventData{EventType: eventType, Db: db, Table: table, Timestamp: ts,
+			Before: map[string]interface{}{}, After: map[string]interface{}{}}
+		if eventType == river.EventTypeDelete {
+			event.Before["id"] = id
+		} else {
+			event.After["id"] = id
+		}
+		return event
+	}
+	events := []*river.EventData{
+		{EventType: river.EventTypeGTID},
+		row(river.EventTypeInsert, "db", "user", 1),
+		row(river.EventTypeInsert, "db", "user", 2),
+		row(river.EventTypeInsert, "other", "user", 3), // 被过滤, 不计数
+		row(river.EventTypeDelete, "db", "order", 1),
+		{EventType: river.EventTypeXID, LogName: "mysql-bin.000001", LogPos: 1234, Timestamp: ts},
+		{EventType: river.EventTypeGTID},
+		{EventType: river.EventTypeXID, LogName: "mysql-bin.000001", LogPos: 2048, Timestamp: ts},
+	}
+
+	var buf bytes.Buffer
+	handler := mustNew(t, &Config{DBs: []string{"db"}, Transaction: true, Writer: &buf})
+	for _, event := range events {
+		if err := handler.OnEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := strings.Join([]string{
+		"BEGIN;",
+		"INSERT INTO `db`.`user`(`id`) VALUES (1);",
+		"INSERT INTO `db`.`user`(`id`) VALUES (2);",
+		"DELETE FROM `db`.`order` WHERE `id`=1 LIMIT 1;",
+		"COMMIT; /* 2023-02-05 21:27:45 at mysql-bin.000001:1234, affected rows: 3 (insert 2, update 0, delete 1); " +
+			"`db`.`user` 2 (insert 2, update 0, delete 0); `db`.`order` 1 (insert 0, update 0, delete 1) */",
+	}, "\n") + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestTraceLogHandler_TransactionMultiDB(t *testing.T) {
+	row := func(db string) *river.EventData {
+		return &river.EventData{EventType: river.EventTypeInsert, Db: db, Table: "user",
+			Before: map[string]interface{}{}, After: map[string]interface{}{"id": 1}}
+	}
+	events := []*river.EventData{
+		{EventType: river.EventTypeGTID},
+		row("db1"),
+		row("db2"),
+		row("db1"),
+		{EventType: river.EventTypeXID, LogName: "mysql-bin.000001", LogPos: 1234},
+	}
+
+	var buf bytes.Buffer
+	handler := mustNew(t, &Config{Transaction: true, Writer: &buf})
+	for _, event := range events {
+		if err := handler.OnEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 5 || lines[0] != "BEGIN;" || !strings.HasPrefix(lines[4], "COMMIT;") {
+		t.Errorf("got\n%s", buf.String())
+	}
+	if n := strings.Count(buf.String(), "BEGIN;"); n != 1 {
+		t.Errorf("got %d BEGIN, want 1", n)
+	}
+}
+
+func TestTraceLogHandler_Summary(t *testing.T) {
+	var buf bytes.Buffer
+	handler := mustNew(t, &Config{SummaryInterval: time.Hour, SummaryTopN: 1, Format: FormatJSON, Writer: &buf})
+	for i := 0; i < 3; i++ {
+		table := "user"
+		if i == 0 {
+			table = "order"
+		}
+		event := &river.EventData{EventType: river.EventTypeInsert, Db: "db", Table: table,
+			After: map[string]interface{}{"id": i}}
+		if err := handler.OnEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf.Reset()
+	handler.OnClose(nil)
+
+	want := `"rows":[{"db":"db","table":"user","insert":2,"update":0,"delete":0}]}`
+	if got := buf.String(); !strings.HasPrefix(got, `{"type":"summary"`) || !strings.HasSuffix(got, want+"\n") {
+		t.Errorf("got %s", got)
+	}
+}
@@ -1,10 +1,16 @@
 package trace_log
 
 import (
+	"encoding/hex"
 	"fmt"
+	"github.com/juju/errors"
 	"github.com/obgnail/mysql-river/river"
+	"io"
 	"reflect"
+	"sort"
 	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 const (
@@ -22,58 +28,162 @@ const (
 )
 
 type Config struct {
-	DBs          []string
-	EntireFields bool // show all field message in update sql
-	ShowTxMsg    bool // show transition msg in sql
-	Highlight    bool // sql highlight
+	DBs           []string `toml:"dbs"`
+	Tables        []string `toml:"tables"`         // only output tables matching these db.table patterns (path.Match syntax), empty means all
+	ExcludeTables []string `toml:"exclude_tables"` // do not output tables matching these db.table patterns
+	RedactColumns []string `toml:"redact_columns"` // mask values of these columns, format: column or db.table.column, wildcards allowed; not allowed with flashback
+	EntireFields  bool     `toml:"entire_fields"`  // show all field message in update sql
+	ShowTxMsg     bool     `toml:"show_tx_msg"`    // show transition msg in sql
+	Highlight     bool     `toml:"highlight"`      // sql highlight
+	Format        string   `toml:"format"`         // output format: sql, json or binlog, default sql
+
+	Transaction     bool          `toml:"transaction"`      // wrap each transaction in BEGIN/COMMIT, annotated with commit time and row counts per table
+	SummaryInterval time.Duration `toml:"summary_interval"` // print the busiest tables periodically, 0 means disable
+	SummaryTopN     int           `toml:"summary_top_n"`    // number of tables in the summary, default 10
+
+	Flashback *FlashbackConfig `toml:"flashback"` // output flashback sql instead, nil means disable
+	Search    *SearchConfig    `toml:"search"`    // only output row changes matching the search, nil means disable
+
+	Writer io.Writer     `toml:"-"`      // where to write sql, default os.Stdout
+	Output *OutputConfig `toml:"output"` // write sql to rotated files instead of Writer, nil means disable
 }
 
 type TraceLogHandler struct {
-	config *Config
-	dbs    map[string]struct{} // map[db]struct{}
+	config    *Config
+	filter    *filter
+	flashback *flashback
+	search    *search
+	output    *output
+	tx        *transaction
+	summary   *summary
 
 	river.NopCloserAlerter
 }
 
 var _ river.Handler = (*TraceLogHandler)(nil)
 
-func New(config *Config) *TraceLogHandler {
-	return &TraceLogHandler{dbs: list2map(config.DBs), config: config}
+// Check 检查配置是否合法. 配置来自 toml 或命令行参数时, 在 New 之前调用
+func (c *Config) Check() error {
+	if len(c.Format) != 0 && !validFormat(c.Format) {
+		return fmt.Errorf("invalid trace log format: %s", c.Format)
+	}
+	if _, err := newFilter(c); err != nil {
+		return errors.Annotate(err, "invalid trace log filter")
+	}
+	if c.Flashback != nil {
+		// 闪回sql用于恢复数据, 不能包含脱敏后的值
+		if len(c.RedactColumns) != 0 {
+			return fmt.Errorf("redact_columns can not be used with flashback")
+		}
+		if _, err := newFlashback(c.Flashback); err != nil {
+			return errors.Annotate(err, "invalid flashback config")
+		}
+	}
+	if c.Search != nil {
+		if _, err := newSearch(c.Search); err != nil {
+			return errors.Annotate(err, "invalid search config")
+		}
+	}
+	return nil
+}
+
+// New 配置不合法时返回error, 见 Config.Check
+func New(config *Config) (*TraceLogHandler, error) {
+	if err := config.Check(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(config.Format) == 0 {
+		config.Format = FormatSQL
+	}
+	// 以下配置已经由 Check 检查
+	t := &TraceLogHandler{
+		config: config,
+		output: newOutput(config.Writer, config.Output),
+	}
+	t.filter, _ = newFilter(config)
+	if config.Flashback != nil {
+		t.flashback, _ = newFlashback(config.Flashback)
+	}
+	if config.Search != nil {
+		t.search, _ = newSearch(config.Search)
+	}
+	if config.SummaryInterval > 0 {
+		if config.SummaryTopN <= 0 {
+			config.SummaryTopN = defaultSummaryTopN
+		}
+		t.summary = newSummary()
+		go t.loopSummary()
+	}
+	return t, nil
 }
 
 func (t *TraceLogHandler) String() string {
 	return "trace log"
 }
 
-func (t *TraceLogHandler) OnEvent(event *river.EventData) error {
-	var data string
-
-	switch event.EventType {
-	case river.EventTypeUpdate, river.EventTypeInsert, river.EventTypeDelete:
-		data = t.handlerRow(event)
-	case river.EventTypeGTID:
-		if t.config.ShowTxMsg {
-			data = fmt.Sprintf("/* GTID: %s */", event.GTIDSet)
+func (t *TraceLogHandler) OnClose(*river.River) {
+	if t.summary != nil {
+		t.summary.stop()
+		if err := t.printSummary(); err != nil {
+			river.Logger.Errorf("print trace log summary error: %s", errors.ErrorStack(err))
 		}
-	case river.EventTypeXID:
-		if t.config.ShowTxMsg {
-			data = fmt.Sprintf("/* XID: %s */", event.Position())
+	}
+	if t.flashback != nil {
+		if err := t.flushFlashback(); err != nil {
+			river.Logger.Errorf("flush flashback sql error: %s", errors.ErrorStack(err))
 		}
-	case river.EventTypeDDL:
-		data = event.SQL
+	}
+	if err := t.output.Close(); err != nil {
+		river.Logger.Errorf("close trace log output error: %s", errors.ErrorStack(err))
+	}
+}
+
+func (t *TraceLogHandler) OnEvent(event *river.EventData) error {
+	if t.flashback != nil {
+		return t.onFlashbackEvent(event)
+	}
+	if t.search != nil {
+		return t.onSearchEvent(event)
 	}
 
+	if t.summary != nil && isRowEvent(event) && !t.skip(event) {
+		t.summary.add(event)
+	}
+	if t.config.Transaction {
+		if err := t.beforeTx(event); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	data, err := t.format(t.filter.redact(event))
+	if err != nil {
+		return errors.Trace(err)
+	}
 	if len(data) != 0 {
-		fmt.Println(data)
+		if err := t.output.write(event.Db, data); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if t.config.Transaction {
+		return errors.Trace(t.afterTx(event))
 	}
 	return nil
 }
 
+func isRowEvent(event *river.EventData) bool {
+	switch event.EventType {
+	case river.EventTypeUpdate, river.EventTypeInsert, river.EventTypeDelete:
+		return true
+	}
+	return false
+}
+
+func (t *TraceLogHandler) skip(event *river.EventData) bool {
+	return t.filter.skip(event)
+}
+
 func (t *TraceLogHandler) handlerRow(event *river.EventData) (sql string) {
-	if len(t.dbs) != 0 {
-		if _, ok := t.dbs[event.Db]; !ok {
-			return ""
-		}
+	if t.skip(event) {
+		return ""
 	}
 
 	switch event.EventType {
@@ -90,11 +200,11 @@ func (t *TraceLogHandler) handlerRow(event *river.EventData) (sql string) {
 func GenUpdateSql(event *river.EventData, highlight bool, showAllField bool) string {
 	var setFields []string
 	if !showAllField {
-		setFields = buildSimpleSqlKVExp(event.Before, event.After)
+		setFields = buildSimpleSqlKVExp(event, event.Before, event.After)
 	} else {
-		setFields = buildSqlKVExp(event.After, false)
+		setFields = buildSqlKVExp(event, event.After, false)
 	}
-	whereFields := buildSqlKVExp(event.Before, true)
+	whereFields := buildWhereExp(event, event.Before)
 
 	formatter := SqlNormalUpdateFormat
 	if highlight {
@@ -102,8 +212,8 @@ func GenUpdateSql(event *river.EventData, highlight bool, showAllField bool) str
 	}
 	content := fmt.Sprintf(
 		formatter,
-		event.Db,
-		event.Table,
+		escapeIdentifier(event.Db),
+		escapeIdentifier(event.Table),
 		strings.Join(setFields, ", "),
 		strings.Join(whereFields, " AND "),
 	)
@@ -111,7 +221,7 @@ func GenUpdateSql(event *river.EventData, highlight bool, showAllField bool) str
 }
 
 func GenInsertSql(event *river.EventData, highlight bool) string {
-	fields, values := map2list(event.After)
+	fields, values := map2list(event, event.After)
 
 	formatter := SqlNormalInsertFormat
 	if highlight {
@@ -119,8 +229,8 @@ func GenInsertSql(event *river.EventData, highlight bool) string {
 	}
 	content := fmt.Sprintf(
 		formatter,
-		event.Db,
-		event.Table,
+		escapeIdentifier(event.Db),
+		escapeIdentifier(event.Table),
 		strings.Join(fields, ", "),
 		strings.Join(values, ", "),
 	)
@@ -128,7 +238,7 @@ func GenInsertSql(event *river.EventData, highlight bool) string {
 }
 
 func GenDeleteSql(event *river.EventData, highlight bool) string {
-	kv := buildSqlKVExp(event.Before, true)
+	kv := buildWhereExp(event, event.Before)
 
 	formatter := SqlNormalDeleteFormat
 	if highlight {
@@ -136,27 +246,44 @@ func GenDeleteSql(event *river.EventData, highlight bool) string {
 	}
 	content := fmt.Sprintf(
 		formatter,
-		event.Db,
-		event.Table,
+		escapeIdentifier(event.Db),
+		escapeIdentifier(event.Table),
 		strings.Join(kv, " AND "),
 	)
 	return content
 }
 
-func buildSqlKVExp(kv map[string]interface{}, inWhere bool) []string {
+func buildSqlKVExp(event *river.EventData, kv map[string]interface{}, inWhere bool) []string {
 	var res []string
-	for field, value := range kv {
-		valueStr := buildSqlValue(value)
+	for _, field := range sortFields(event, kv) {
+		valueStr := buildSqlValue(kv[field], event.Column(field))
 		res = append(res, buildEqualExp(field, valueStr, inWhere))
 	}
 	return res
 }
 
-func buildSimpleSqlKVExp(before, after map[string]interface{}) []string {
+// buildWhereExp 有主键时只使用主键定位行, 没有主键的表才使用所有字段
+func buildWhereExp(event *river.EventData, kv map[string]interface{}) []string {
+	if len(event.Primary) == 0 {
+		return buildSqlKVExp(event, kv, true)
+	}
+	primary := make(map[string]interface{}, len(event.Primary))
+	for _, field := range event.Primary {
+		value, ok := kv[field]
+		if !ok {
+			return buildSqlKVExp(event, kv, true)
+		}
+		primary[field] = value
+	}
+	return buildSqlKVExp(event, primary, true)
+}
+
+func buildSimpleSqlKVExp(event *river.EventData, before, after map[string]interface{}) []string {
 	var res []string
-	for field, value := range after {
-		afterValue := buildSqlValue(value)
-		beforeValue := buildSqlValue(before[field])
+	for _, field := range sortFields(event, after) {
+		column := event.Column(field)
+		afterValue := buildSqlValue(after[field], column)
+		beforeValue := buildSqlValue(before[field], column)
 		if beforeValue != afterValue {
 			res = append(res, buildEqualExp(field, afterValue, false))
 		}
@@ -164,15 +291,26 @@ func buildSimpleSqlKVExp(before, after map[string]interface{}) []string {
 	return res
 }
 
-func buildSqlValue(value interface{}) string {
+// buildSqlValue 将字段值转为可以直接在mysql客户端中执行的字面量. column为nil时根据值的类型推断
+func buildSqlValue(value interface{}, column *river.Column) string {
 	if value == nil {
 		return "NULL"
 	}
 
+	switch v := value.(type) {
+	case time.Time: // canal ParseTime
+		if column != nil && column.Type == river.ColumnTypeDate {
+			return quoteString(v.Format("2006-01-02"))
+		}
+		return quoteString(v.Format("2006-01-02 15:04:05.999999"))
+	case fmt.Stringer: // decimal.Decimal, canal UseDecimal
+		return buildStringValue(v.String(), column)
+	}
+
 	fieldType := reflect.TypeOf(value)
 	switch fieldType.Kind() {
 	case reflect.String:
-		return fmt.Sprintf("'%v'", value)
+		return buildStringValue(reflect.ValueOf(value).String(), column)
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
 		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
 		return fmt.Sprintf("%v", value)
@@ -180,13 +318,16 @@ func buildSqlValue(value interface{}) string {
 		return fmt.Sprintf("%v", value)
 	case reflect.Bool:
 		return fmt.Sprintf("%v", value)
-		// text, longtext
+		// text, blob, geometry
 	case reflect.Slice:
 		s, ok := reflect.ValueOf(value).Interface().([]byte)
 		if !ok {
 			return fmt.Sprintf(InvalidFormat, value)
 		}
-		return fmt.Sprintf("'%s'", string(s))
+		if (column != nil && column.IsBinary()) || (column == nil && !utf8.Valid(s)) {
+			return buildHexValue(s)
+		}
+		return buildStringValue(string(s), column)
 	case reflect.Uintptr, reflect.Complex64, reflect.Complex128, reflect.Array, reflect.Chan,
 		reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Struct,
 		reflect.UnsafePointer:
@@ -198,7 +339,76 @@ func buildSqlValue(value interface{}) string {
 	}
 }
 
+func buildStringValue(s string, column *river.Column) string {
+	if column == nil {
+		return quoteString(s)
+	}
+	switch {
+	case column.Type == river.ColumnTypeDecimal && isDecimal(s):
+		return s
+	case column.Type == river.ColumnTypeJSON:
+		return fmt.Sprintf("CAST(%s AS JSON)", quoteString(s))
+	case column.IsBinary():
+		return buildHexValue([]byte(s))
+	}
+	return quoteString(s)
+}
+
+// buildHexValue 二进制数据使用十六进制字面量, 避免不可见字符和编码问题
+func buildHexValue(b []byte) string {
+	if len(b) == 0 {
+		return "''"
+	}
+	return "X'" + hex.EncodeToString(b) + "'"
+}
+
+// quoteString 按照 mysql_real_escape_string 的规则转义字符串并加上单引号
+func quoteString(s string) string {
+	var buf strings.Builder
+	buf.Grow(len(s) + 2)
+	buf.WriteByte('\'')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case 0:
+			buf.WriteString(`\0`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\'':
+			buf.WriteString(`\'`)
+		case '"':
+			buf.WriteString(`\"`)
+		case '\032':
+			buf.WriteString(`\Z`)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	buf.WriteByte('\'')
+	return buf.String()
+}
+
+func isDecimal(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; (c < '0' || c > '9') && c != '.' && c != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+func escapeIdentifier(name string) string {
+	return strings.ReplaceAll(name, "`", "``")
+}
+
 func buildEqualExp(key, value string, inWhere bool) string {
+	key = escapeIdentifier(key)
 	// if v is NULL, may need to process
 	if inWhere && value == "NULL" {
 		return fmt.Sprintf("`%s` IS %s", key, value)
@@ -215,10 +425,32 @@ func list2map(slice []string) map[string]struct{} {
 	return res
 }
 
-func map2list(kv map[string]interface{}) (fields []string, values []string) {
-	for filed, value := range kv {
-		fields = append(fields, fmt.Sprintf("`%s`", filed))
-		values = append(values, buildSqlValue(value))
+func map2list(event *river.EventData, kv map[string]interface{}) (fields []string, values []string) {
+	for _, filed := range sortFields(event, kv) {
+		fields = append(fields, fmt.Sprintf("`%s`", escapeIdentifier(filed)))
+		values = append(values, buildSqlValue(kv[filed], event.Column(filed)))
 	}
 	return
 }
+
+// sortFields 按照表定义的顺序返回kv中的字段, 不在表定义中的字段按字典序排在最后
+func sortFields(event *river.EventData, kv map[string]interface{}) []string {
+	fields := make([]string, 0, len(kv))
+	for _, column := range event.Columns {
+		if _, ok := kv[column.Name]; ok {
+			fields = append(fields, column.Name)
+		}
+	}
+	if len(fields) == len(kv) {
+		return fields
+	}
+
+	var rest []string
+	for field := range kv {
+		if event.Column(field) == nil {
+			rest = append(rest, field)
+		}
+	}
+	sort.Strings(rest)
+	return append(fields, rest...)
+}
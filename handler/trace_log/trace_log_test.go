@@ -0,0 +1,148 @@
+package trace_log
+
+import (
+	"github.com/obgnail/mysql-river/river"
+	"testing"
+	"time"
+)
+
+func TestBuildSqlValue(t *testing.T) {
+	var (
+		varchar = &river.Column{Name: "name", Type: river.ColumnTypeString, RawType: "varchar(32)"}
+		text    = &river.Column{Name: "content", Type: river.ColumnTypeString, RawType: "text"}
+		blob    = &river.Column{Name: "avatar", Type: river.ColumnTypeString, RawType: "blob"}
+		binary  = &river.Column{Name: "uuid", Type: river.ColumnTypeBinary, RawType: "binary(4)"}
+		decimal = &river.Column{Name: "price", Type: river.ColumnTypeDecimal, RawType: "decimal(10,2)"}
+		json    = &river.Column{Name: "extra", Type: river.ColumnTypeJSON, RawType: "json"}
+		date    = &river.Column{Name: "birthday", Type: river.ColumnTypeDate, RawType: "date"}
+	)
+	cases := []struct {
+		value  interface{}
+		column *river.Column
+		want   string
+	}{
+		{nil, varchar, "NULL"},
+		{int64(-1), nil, "-1"},
+		{uint64(18446744073709551615), nil, "18446744073709551615"},
+		{1.5, nil, "1.5"},
+		{"it's", varchar, `'it\'s'`},
+		{`C:\path`, varchar, `'C:\\path'`},
+		{"a\nb\r\x00\x1a\"", varchar, `'a\nb\r\0\Z\"'`},
+		{[]byte("it's text"), text, `'it\'s text'`},
+		{[]byte{0x00, 0xff, '\''}, blob, "X'00ff27'"},
+		{[]byte{}, blob, "''"},
+		{[]byte{0xff, 0xfe}, nil, "X'fffe'"},
+		{"\x01\x02\x03\x04", binary, "X'01020304'"},
+		{"12.50", decimal, "12.50"},
+		{"12.50", nil, "'12.50'"},
+		{`{"k": "it's"}`, json, `CAST('{\"k\": \"it\'s\"}' AS JSON)`},
+		{"2023-02-05 21:27:45", nil, "'2023-02-05 21:27:45'"},
+		{time.Date(2023, 2, 5, 21, 27, 45, 500000000, time.UTC), nil, "'2023-02-05 21:27:45.5'"},
+		{time.Date(2023, 2, 5, 0, 0, 0, 0, time.UTC), date, "'2023-02-05'"},
+	}
+	for _, c := range cases {
+		if got := buildSqlValue(c.value, c.column); got != c.want {
+			t.Errorf("buildSqlValue(%#v): got %s, want %s", c.value, got, c.want)
+		}
+	}
+}
+
+func TestGenInsertSql_EscapeIdentifier(t *testing.T) {
+	event := &river.EventData{
+		EventType: river.EventTypeInsert,
+		Db:        "db",
+		Table:     "we`ird",
+		After:     map[string]interface{}{"na`me": "x"},
+	}
+	want := "INSERT INTO `db`.`we``ird`(`na``me`) VALUES ('x');"
+	if got := GenInsertSql(event, false); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestGenUpdateSql_PrimaryWhere(t *testing.T) {
+	event := &river.EventData{
+		EventType: river.EventTypeUpdate,
+		Db:        "db",
+		Table:     "user",
+		Primary:   []string{"id"},
+		Before:    map[string]interface{}{"id": 1, "score": 1.1},
+		After:     map[string]interface{}{"id": 1, "score": 2.2},
+	}
+	want := "UPDATE `db`.`user` SET `score`=2.2 WHERE `id`=1 LIMIT 1;"
+	if got := GenUpdateSql(event, false, false); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	event.EventType = river.EventTypeDelete
+	event.Primary = nil
+	event.Before = map[string]interface{}{"name": nil}
+	want = "DELETE FROM `db`.`user` WHERE `name` IS NULL LIMIT 1;"
+	if got := GenDeleteSql(event, false); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestGenSql_ColumnOrder(t *testing.T) {
+	event := &river.EventData{
+		EventType: river.EventTypeInsert,
+		Db:        "db",
+		Table:     "user",
+		Columns: []*river.Column{
+			{Name: "id", Type: river.ColumnTypeNumber},
+			{Name: "name", Type: river.ColumnTypeString},
+			{Name: "age", Type: river.ColumnTypeNumber},
+		},
+		After: map[string]interface{}{"age": 18, "id": 1, "name": "lihua"},
+	}
+	want := "INSERT INTO `db`.`user`(`id`, `name`, `age`) VALUES (1, 'lihua', 18);"
+	for i := 0; i < 10; i++ {
+		if got := GenInsertSql(event, false); got != want {
+			t.Fatalf("got %s, want %s", got, want)
+		}
+	}
+
+	event.Columns = nil
+	want = "INSERT INTO `db`.`user`(`age`, `id`, `name`) VALUES (18, 1, 'lihua');"
+	if got := GenInsertSql(event, false); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestConfig_Check(t *testing.T) {
+	valid := []*Config{
+		{},
+		{Format: FormatJSON, Tables: []string{"shop.*"}, RedactColumns: []string{"password"}},
+		{Flashback: &FlashbackConfig{StartPos: "mysql-bin.000001:4"}},
+		{Search: &SearchConfig{Where: []string{"id=1"}}},
+	}
+	for i, config := range valid {
+		if err := config.Check(); err != nil {
+			t.Errorf("config %d: %s", i, err)
+		}
+	}
+	invalid := []*Config{
+		{Format: "xml"},
+		{Tables: []string{"shop.[a"}},
+		{RedactColumns: []string{"a.b"}},
+		{Flashback: &FlashbackConfig{StartPos: "mysql-bin.000001"}},
+		{Flashback: &FlashbackConfig{}, RedactColumns: []string{"password"}},
+		{Search: &SearchConfig{Where: []string{"id"}}},
+	}
+	for i, config := range invalid {
+		if err := config.Check(); err == nil {
+			t.Errorf("config %d: expect error", i)
+		}
+		if _, err := New(config); err == nil {
+			t.Errorf("config %d: expect New returns error", i)
+		}
+	}
+}
+
+func mustNew(t *testing.T, config *Config) *TraceLogHandler {
+	handler, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return handler
+}
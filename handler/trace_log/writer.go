@@ -0,0 +1,197 @@
+package trace_log
+
+import (
+	"fmt"
+	"github.com/juju/errors"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+const (
+	defaultOutputFileName = "trace.sql"
+	rotateTimeFormat      = "20060102-150405"
+)
+
+// OutputConfig 将trace log写入文件, 支持按大小、时间轮转和按库拆分文件
+type OutputConfig struct {
+	Dir            string        `toml:"dir"`
+	FileName       string        `toml:"file_name"`       // 默认 trace.sql
+	PerDatabase    bool          `toml:"per_database"`    // 每个库写入单独的 <db>.sql, 不属于任何库的内容(如事务信息)写入 FileName
+	MaxSize        int64         `toml:"max_size"`        // 单个文件的最大字节数, 超过后轮转, 为0时不按大小轮转
+	RotateInterval time.Duration `toml:"rotate_interval"` // 文件的最长写入时间, 超过后轮转, 为0时不按时间轮转
+}
+
+// RotateWriter 按大小和时间轮转的文件writer, 轮转时将当前文件重命名为 <path>.<20060102-150405>
+type RotateWriter struct {
+	sync.Mutex
+	path     string
+	maxSize  int64
+	interval time.Duration
+
+	file     *os.File
+	size     int64
+	openTime time.Time
+}
+
+var _ io.WriteCloser = (*RotateWriter)(nil)
+
+func NewRotateWriter(path string, maxSize int64, interval time.Duration) (*RotateWriter, error) {
+	w := &RotateWriter{path: path, maxSize: maxSize, interval: interval}
+	if err := w.open(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return w, nil
+}
+
+func (w *RotateWriter) open() error {
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return errors.Trace(err)
+	}
+	w.file = f
+	w.size = info.Size()
+	w.openTime = time.Now()
+	return nil
+}
+
+func (w *RotateWriter) needRotate(n int) bool {
+	if w.size == 0 {
+		return false
+	}
+	if w.maxSize > 0 && w.size+int64(n) > w.maxSize {
+		return true
+	}
+	return w.interval > 0 && time.Since(w.openTime) > w.interval
+}
+
+func (w *RotateWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return errors.Trace(err)
+	}
+	backup := fmt.Sprintf("%s.%s", w.path, time.Now().Format(rotateTimeFormat))
+	for i := 1; ; i++ {
+		if _, err := os.Stat(backup); os.IsNotExist(err) {
+			break
+		}
+		backup = fmt.Sprintf("%s.%s.%d", w.path, time.Now().Format(rotateTimeFormat), i)
+	}
+	if err := os.Rename(w.path, backup); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(w.open())
+}
+
+func (w *RotateWriter) Write(p []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+	if w.needRotate(len(p)) {
+		if err := w.rotate(); err != nil {
+			return 0, errors.Trace(err)
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, errors.Trace(err)
+}
+
+func (w *RotateWriter) Close() error {
+	w.Lock()
+	defer w.Unlock()
+	return errors.Trace(w.file.Close())
+}
+
+// output 根据配置将内容写入 Config.Writer 或文件
+type output struct {
+	sync.Mutex
+	writer  io.Writer
+	config  *OutputConfig
+	writers map[string]*RotateWriter // map[fileName]*RotateWriter
+}
+
+func newOutput(writer io.Writer, config *OutputConfig) *output {
+	if writer == nil {
+		writer = os.Stdout
+	}
+	if config != nil && len(config.FileName) == 0 {
+		config.FileName = defaultOutputFileName
+	}
+	return &output{writer: writer, config: config, writers: make(map[string]*RotateWriter)}
+}
+
+// write 写入一行内容, db为空表示内容不属于任何库
+func (o *output) write(db string, data string) error {
+	o.Lock()
+	defer o.Unlock()
+	w, err := o.getWriter(db)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	_, err = io.WriteString(w, data+"\n")
+	return errors.Trace(err)
+}
+
+// writeEach 在每个库对应的文件中写入一行内容, 同一个文件只写入一次
+func (o *output) writeEach(dbs []string, data string) error {
+	written := make(map[string]struct{})
+	for _, db := range dbs {
+		fileName := o.fileName(db)
+		if _, ok := written[fileName]; ok {
+			continue
+		}
+		written[fileName] = struct{}{}
+		if err := o.write(db, data); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+func (o *output) fileName(db string) string {
+	if o.config == nil {
+		return ""
+	}
+	if o.config.PerDatabase && len(db) != 0 {
+		return db + ".sql"
+	}
+	return o.config.FileName
+}
+
+func (o *output) getWriter(db string) (io.Writer, error) {
+	if o.config == nil {
+		return o.writer, nil
+	}
+	fileName := o.fileName(db)
+	if w, ok := o.writers[fileName]; ok {
+		return w, nil
+	}
+	if err := os.MkdirAll(o.config.Dir, 0755); err != nil {
+		return nil, errors.Trace(err)
+	}
+	w, err := NewRotateWriter(path.Join(o.config.Dir, fileName), o.config.MaxSize, o.config.RotateInterval)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	o.writers[fileName] = w
+	return w, nil
+}
+
+func (o *output) Close() error {
+	o.Lock()
+	defer o.Unlock()
+	var firstErr error
+	for fileName, w := range o.writers {
+		if err := w.Close(); err != nil && firstErr == nil {
+			firstErr = errors.Trace(err)
+		}
+		delete(o.writers, fileName)
+	}
+	return firstErr
+}
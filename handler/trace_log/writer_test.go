@@ -0,0 +1,77 @@
+package trace_log
+
+import (
+	"bytes"
+	"github.com/obgnail/mysql-river/river"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotateWriter(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewRotateWriter(filepath.Join(dir, "trace.sql"), 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"12345\n", "67890\n", "abc\n"} {
+		if _, err := w.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "trace.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "67890\nabc\n" {
+		t.Errorf("got %q", content)
+	}
+	backups, _ := filepath.Glob(filepath.Join(dir, "trace.sql.*"))
+	if len(backups) != 1 {
+		t.Errorf("expect 1 rotated file, got %v", backups)
+	}
+}
+
+func TestTraceLogHandler_Output(t *testing.T) {
+	insert := func(db string) *river.EventData {
+		return &river.EventData{EventType: river.EventTypeInsert, Db: db, Table: "user",
+			After: map[string]interface{}{"id": 1}}
+	}
+	xid := &river.EventData{EventType: river.EventTypeXID, LogName: "mysql-bin.000001", LogPos: 4}
+
+	var buf bytes.Buffer
+	handler := mustNew(t, &Config{Writer: &buf})
+	if err := handler.OnEvent(insert("db")); err != nil {
+		t.Fatal(err)
+	}
+	if want := "INSERT INTO `db`.`user`(`id`) VALUES (1);\n"; buf.String() != want {
+		t.Errorf("got %q, want %q", buf.String(), want)
+	}
+
+	dir := t.TempDir()
+	handler = mustNew(t, &Config{ShowTxMsg: true, Output: &OutputConfig{Dir: dir, PerDatabase: true}})
+	for _, event := range []*river.EventData{insert("db1"), insert("db2"), xid} {
+		if err := handler.OnEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler.OnClose(nil)
+
+	for fileName, want := range map[string]string{
+		"db1.sql":   "INSERT INTO `db1`.`user`(`id`) VALUES (1);\n",
+		"db2.sql":   "INSERT INTO `db2`.`user`(`id`) VALUES (1);\n",
+		"trace.sql": "/* XID: mysql-bin.000001:4 */\n",
+	} {
+		content, err := ioutil.ReadFile(filepath.Join(dir, fileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != want {
+			t.Errorf("%s: got %q, want %q", fileName, content, want)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package river
+
+import (
+	"fmt"
+	"github.com/go-mysql-org/go-mysql/client"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/go-mysql-org/go-mysql/schema"
+	"github.com/juju/errors"
+	"path/filepath"
+	"strings"
+)
+
+const binaryCollationID = 63
+
+// BinlogFileReader 解析本地的binlog文件, 将event交给Handler, 用于离线分析.
+// 表结构优先使用 TableMapEvent 中的元数据(binlog_row_metadata=FULL), 没有字段名时从 mysql 中查询当前的表结构,
+// 不能连接 mysql 时字段名为 @1、@2 ...
+// event 的 GTIDSet 只包含当前事务的 GTID. Handler 返回 ErrStop 时停止解析.
+type BinlogFileReader struct {
+	config  *MySQLConfig
+	handler Handler
+	parser  *replication.BinlogParser
+
+	conn    *client.Conn
+	schemas map[string]*schema.Table // map[db.table]*schema.Table, 从mysql查询的表结构
+	tables  map[uint64]*schema.Table // map[tableID]*schema.Table
+	gtid    string
+}
+
+// NewBinlogFileReader config 为 nil 时不从 mysql 查询表结构
+func NewBinlogFileReader(config *MySQLConfig, handler Handler) *BinlogFileReader {
+	parser := replication.NewBinlogParser()
+	if config != nil {
+		parser.SetFlavor(config.flavor())
+	}
+	return &BinlogFileReader{
+		config:  config,
+		handler: handler,
+		parser:  parser,
+		schemas: make(map[string]*schema.Table),
+		tables:  make(map[uint64]*schema.Table),
+	}
+}
+
+// Read 按顺序解析 files, 结束或出错后调用 handler.OnClose, River.Error 为解析失败的error
+func (r *BinlogFileReader) Read(files ...string) (err error) {
+	defer func() {
+		r.handler.OnClose(&River{Error: err})
+	}()
+	if r.config != nil {
+		if err := r.config.Check(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	for _, file := range files {
+		logName := filepath.Base(file)
+		err := r.parser.ParseFile(file, 0, func(e *replication.BinlogEvent) error {
+			return r.onEvent(logName, e)
+		})
+		if IsStop(err) {
+			return nil
+		}
+		if err != nil {
+			return errors.Annotatef(err, "parse %s", file)
+		}
+	}
+	return nil
+}
+
+func (r *BinlogFileReader) Close() error {
+	if r.conn != nil {
+		return errors.Trace(r.conn.Close())
+	}
+	return nil
+}
+
+func (r *BinlogFileReader) onEvent(logName string, e *replication.BinlogEvent) error {
+	newEvent := func(eventType string) *EventData {
+		return &EventData{
+			EventType: eventType,
+			ServerID:  e.Header.ServerID,
+			LogName:   logName,
+			LogPos:    e.Header.LogPos,
+			GTIDSet:   r.gtid,
+			Primary:   []string{},
+			Before:    make(map[string]interface{}),
+			After:     make(map[string]interface{}),
+			Timestamp: e.Header.Timestamp,
+		}
+	}
+
+	switch ev := e.Event.(type) {
+	case *replication.GTIDEvent:
+		sid := ev.SID
+		r.gtid = fmt.Sprintf("%x-%x-%x-%x-%x:%d", sid[0:4], sid[4:6], sid[6:8], sid[8:10], sid[10:16], ev.GNO)
+		return errors.Trace(r.handler.OnEvent(newEvent(EventTypeGTID)))
+	case *replication.MariadbGTIDEvent:
+		r.gtid = ev.GTID.String()
+		return errors.Trace(r.handler.OnEvent(newEvent(EventTypeGTID)))
+	case *replication.XIDEvent:
+		return errors.Trace(r.handler.OnEvent(newEvent(EventTypeXID)))
+	case *replication.QueryEvent:
+		query := strings.TrimSpace(string(ev.Query))
+		if strings.EqualFold(query, "BEGIN") || strings.EqualFold(query, "COMMIT") {
+			return nil
+		}
+		// 表结构可能已经变更
+		r.schemas = make(map[string]*schema.Table)
+		data := newEvent(EventTypeDDL)
+		data.Db = string(ev.Schema)
+		data.SQL = query
+		return errors.Trace(r.handler.OnEvent(data))
+	case *replication.TableMapEvent:
+		table, err := r.buildTable(ev)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		r.tables[ev.TableID] = table
+	case *replication.RowsEvent:
+		return errors.Trace(r.onRows(e.Header, ev, newEvent))
+	}
+	return nil
+}
+
+func (r *BinlogFileReader) onRows(header *replication.EventHeader, ev *replication.RowsEvent, newEvent func(string) *EventData) error {
+	table, ok := r.tables[ev.TableID]
+	if !ok {
+		return fmt.Errorf("table map event of table id %d not found", ev.TableID)
+	}
+
+	var action string
+	switch header.EventType {
+	case replication.WRITE_ROWS_EVENTv0, replication.WRITE_ROWS_EVENTv1, replication.WRITE_ROWS_EVENTv2:
+		action = EventTypeInsert
+	case replication.UPDATE_ROWS_EVENTv0, replication.UPDATE_ROWS_EVENTv1, replication.UPDATE_ROWS_EVENTv2:
+		action = EventTypeUpdate
+	case replication.DELETE_ROWS_EVENTv0, replication.DELETE_ROWS_EVENTv1, replication.DELETE_ROWS_EVENTv2:
+		action = EventTypeDelete
+	default:
+		return nil
+	}
+	handleUnsigned(table, ev.Rows)
+
+	step := 1
+	if action == EventTypeUpdate {
+		step = 2
+	}
+	for i := 0; i+step <= len(ev.Rows); i += step {
+		data := newEvent(action)
+		data.Db = table.Schema
+		data.Table = table.Name
+		data.Columns = buildColumns(table.Columns)
+		for _, idx := range table.PKColumns {
+			data.Primary = append(data.Primary, table.Columns[idx].Name)
+		}
+		switch action {
+		case EventTypeInsert:
+			data.After = buildFields(table.Columns, ev.Rows[i])
+		case EventTypeDelete:
+			data.Before = buildFields(table.Columns, ev.Rows[i])
+		case EventTypeUpdate:
+			data.Before = buildFields(table.Columns, ev.Rows[i])
+			data.After = buildFields(table.Columns, ev.Rows[i+1])
+		}
+		if err := r.handler.OnEvent(data); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// buildTable 根据 TableMapEvent 构造表结构
+func (r *BinlogFileReader) buildTable(ev *replication.TableMapEvent) (*schema.Table, error) {
+	db, name := string(ev.Schema), string(ev.Table)
+	if len(ev.ColumnName) == 0 {
+		table, err := r.querySchema(db, name)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if table != nil && len(table.Columns) == int(ev.ColumnCount) {
+			return table, nil
+		}
+		if table != nil {
+			Logger.Warnf("columns of %s.%s changed since the binlog was written, use @N as column names", db, name)
+		}
+	}
+
+	table := &schema.Table{Schema: db, Name: name}
+	names := ev.ColumnNameString()
+	unsigned := ev.UnsignedMap()
+	collations := ev.CollationMap()
+	enums := ev.EnumStrValueMap()
+	sets := ev.SetStrValueMap()
+	for i := 0; i < int(ev.ColumnCount); i++ {
+		columnName := fmt.Sprintf("@%d", i+1)
+		if i < len(names) {
+			columnName = names[i]
+		}
+		rawType := mapEventColumnType(ev, i, collations[i] == binaryCollationID)
+		switch {
+		case ev.IsEnumColumn(i) && len(enums[i]) != 0:
+			rawType = fmt.Sprintf("enum('%s')", strings.Join(enums[i], "','"))
+		case ev.IsSetColumn(i) && len(sets[i]) != 0:
+			rawType = fmt.Sprintf("set('%s')", strings.Join(sets[i], "','"))
+		case unsigned[i]:
+			rawType += " unsigned"
+		}
+		table.AddColumn(columnName, rawType, "", "")
+	}
+	for _, idx := range ev.PrimaryKey {
+		table.PKColumns = append(table.PKColumns, int(idx))
+	}
+	return table, nil
+}
+
+// querySchema 从mysql查询表结构, 不能连接mysql或表不存在时返回nil
+func (r *BinlogFileReader) querySchema(db, name string) (*schema.Table, error) {
+	if r.config == nil {
+		return nil, nil
+	}
+	key := db + "." + name
+	if table, ok := r.schemas[key]; ok {
+		return table, nil
+	}
+	if r.conn == nil {
+		conn, err := connect(r.config)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		r.conn = conn
+	}
+	table, err := schema.NewTable(r.conn, db, name)
+	if myErr, ok := errors.Cause(err).(*mysql.MyError); ok && myErr.Code == mysql.ER_NO_SUCH_TABLE {
+		table, err = nil, nil
+	}
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	r.schemas[key] = table
+	return table, nil
+}
+
+// mapEventColumnType 将binlog中的字段类型转为 schema.Table.AddColumn 可以识别的类型
+func mapEventColumnType(ev *replication.TableMapEvent, i int, binary bool) string {
+	if ev.IsEnumColumn(i) {
+		return "enum"
+	}
+	if ev.IsSetColumn(i) {
+		return "set"
+	}
+	switch ev.ColumnType[i] {
+	case mysql.MYSQL_TYPE_TINY:
+		return "tinyint"
+	case mysql.MYSQL_TYPE_SHORT:
+		return "smallint"
+	case mysql.MYSQL_TYPE_INT24:
+		return "mediumint"
+	case mysql.MYSQL_TYPE_LONG:
+		return "int"
+	case mysql.MYSQL_TYPE_LONGLONG:
+		return "bigint"
+	case mysql.MYSQL_TYPE_YEAR:
+		return "year"
+	case mysql.MYSQL_TYPE_FLOAT:
+		return "float"
+	case mysql.MYSQL_TYPE_DOUBLE:
+		return "double"
+	case mysql.MYSQL_TYPE_DECIMAL, mysql.MYSQL_TYPE_NEWDECIMAL:
+		return "decimal"
+	case mysql.MYSQL_TYPE_TIMESTAMP, mysql.MYSQL_TYPE_TIMESTAMP2:
+		return "timestamp"
+	case mysql.MYSQL_TYPE_DATETIME, mysql.MYSQL_TYPE_DATETIME2:
+		return "datetime"
+	case mysql.MYSQL_TYPE_DATE, mysql.MYSQL_TYPE_NEWDATE:
+		return "date"
+	case mysql.MYSQL_TYPE_TIME, mysql.MYSQL_TYPE_TIME2:
+		return "time"
+	case mysql.MYSQL_TYPE_BIT:
+		return "bit"
+	case mysql.MYSQL_TYPE_JSON:
+		return "json"
+	case mysql.MYSQL_TYPE_GEOMETRY:
+		return "geometry"
+	case mysql.MYSQL_TYPE_STRING:
+		if binary {
+			return "binary"
+		}
+		return "char"
+	case mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_VAR_STRING:
+		if binary {
+			return "varbinary"
+		}
+		return "varchar"
+	case mysql.MYSQL_TYPE_BLOB, mysql.MYSQL_TYPE_TINY_BLOB, mysql.MYSQL_TYPE_MEDIUM_BLOB, mysql.MYSQL_TYPE_LONG_BLOB:
+		if binary {
+			return "blob"
+		}
+		return "text"
+	}
+	return "varchar"
+}
+
+// handleUnsigned binlog中的整数均为有符号数, 需要根据表结构转换为无符号数
+func handleUnsigned(table *schema.Table, rows [][]interface{}) {
+	for _, row := range rows {
+		for _, idx := range table.UnsignedColumns {
+			if idx >= len(row) {
+				continue
+			}
+			switch v := row[idx].(type) {
+			case int8:
+				row[idx] = uint8(v)
+			case int16:
+				row[idx] = uint16(v)
+			case int32:
+				if v < 0 && table.Columns[idx].Type == schema.TYPE_MEDIUM_INT {
+					row[idx] = uint32(v) & 0xffffff
+				} else {
+					row[idx] = uint32(v)
+				}
+			case int64:
+				row[idx] = uint64(v)
+			case int:
+				row[idx] = uint(v)
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package river
+
+import (
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/go-mysql-org/go-mysql/schema"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBinlogFileReader_BuildTable(t *testing.T) {
+	newTableMapEvent := func(names ...string) *replication.TableMapEvent {
+		ev := &replication.TableMapEvent{
+			Schema:      []byte("db"),
+			Table:       []byte("user"),
+			ColumnCount: 4,
+			ColumnType: []byte{mysql.MYSQL_TYPE_LONGLONG, mysql.MYSQL_TYPE_VARCHAR,
+				mysql.MYSQL_TYPE_NEWDECIMAL, mysql.MYSQL_TYPE_BLOB},
+			ColumnMeta:       make([]uint16, 4),
+			SignednessBitmap: []byte{0x80}, // bigint unsigned, decimal signed
+			PrimaryKey:       []uint64{0},
+		}
+		for _, name := range names {
+			ev.ColumnName = append(ev.ColumnName, []byte(name))
+		}
+		return ev
+	}
+	r := NewBinlogFileReader(nil, nil)
+	table, err := r.buildTable(newTableMapEvent("id", "name", "price", "content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, c := range table.Columns {
+		got = append(got, c.Name+" "+c.RawType)
+	}
+	want := []string{"id bigint unsigned", "name varchar", "price decimal", "content text"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if !reflect.DeepEqual(table.PKColumns, []int{0}) || !reflect.DeepEqual(table.UnsignedColumns, []int{0}) {
+		t.Errorf("got pk %v, unsigned %v", table.PKColumns, table.UnsignedColumns)
+	}
+
+	// 没有字段名且不能查询表结构
+	if table, err = r.buildTable(newTableMapEvent()); err != nil {
+		t.Fatal(err)
+	}
+	if table.Columns[1].Name != "@2" {
+		t.Errorf("got %s, want @2", table.Columns[1].Name)
+	}
+}
+
+func TestHandleUnsigned(t *testing.T) {
+	table := &schema.Table{}
+	table.AddColumn("a", "tinyint unsigned", "", "")
+	table.AddColumn("b", "mediumint unsigned", "", "")
+	table.AddColumn("c", "bigint", "", "")
+	rows := [][]interface{}{{int8(-1), int32(-1), int64(-1)}}
+	handleUnsigned(table, rows)
+	want := []interface{}{uint8(255), uint32(16777215), int64(-1)}
+	if !reflect.DeepEqual(rows[0], want) {
+		t.Errorf("got %v, want %v", rows[0], want)
+	}
+}
+
+type closeRecorder struct {
+	NopCloserAlerter
+	closed int
+	err    error
+}
+
+func (h *closeRecorder) OnClose(r *River) {
+	h.closed++
+	h.err = r.Error
+}
+
+func TestBinlogFileReader_OnClose(t *testing.T) {
+	h := &closeRecorder{}
+	if err := NewBinlogFileReader(nil, h).Read(); err != nil {
+		t.Fatal(err)
+	}
+	if h.closed != 1 || h.err != nil {
+		t.Errorf("closed %d times, error %v", h.closed, h.err)
+	}
+
+	h = &closeRecorder{}
+	err := NewBinlogFileReader(nil, h).Read(filepath.Join(t.TempDir(), "mysql-bin.000001"))
+	if err == nil {
+		t.Fatal("expect error for missing file")
+	}
+	if h.closed != 1 || h.err != err {
+		t.Errorf("closed %d times, error %v", h.closed, h.err)
+	}
+}
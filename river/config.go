@@ -1,28 +1,79 @@
 package river
 
-import "time"
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/juju/errors"
+	"time"
+)
 
 type MySQLConfig struct {
-	Host     string
-	Port     int64
-	User     string
-	Password string
+	Host     string `toml:"host"`
+	Port     int64  `toml:"port"`
+	User     string `toml:"user"`
+	Password string `toml:"password"`
+
+	Flavor          string        `toml:"flavor"`           // mysql、mariadb, 默认mysql
+	ServerID        uint32        `toml:"server_id"`        // 伪装成slave时使用的server id, 为0时随机生成
+	Charset         string        `toml:"charset"`          // 默认utf8
+	HeartbeatPeriod time.Duration `toml:"heartbeat_period"` // master发送心跳的间隔, 为0时不发送
+	ReadTimeout     time.Duration `toml:"read_timeout"`     // 读取binlog的超时时间, 需大于HeartbeatPeriod
+	TLS             *TLSConfig    `toml:"tls"`              // 为nil时不使用TLS
+}
+
+// Check 检查配置是否合法
+func (c *MySQLConfig) Check() error {
+	switch c.Flavor {
+	case "", mysql.MySQLFlavor, mysql.MariaDBFlavor:
+	default:
+		return fmt.Errorf("invalid flavor: %s", c.Flavor)
+	}
+	return nil
+}
+
+func (c *MySQLConfig) flavor() string {
+	if len(c.Flavor) == 0 {
+		return mysql.MySQLFlavor
+	}
+	return c.Flavor
+}
+
+// ParseGTIDSet 按配置的 flavor 解析GTID集合
+func (c *MySQLConfig) ParseGTIDSet(gtid string) (mysql.GTIDSet, error) {
+	gset, err := mysql.ParseGTIDSet(c.flavor(), gtid)
+	return gset, errors.Trace(err)
+}
+
+func (c *MySQLConfig) tlsConfig() (*tls.Config, error) {
+	if c.TLS == nil {
+		return nil, nil
+	}
+	cfg, err := c.TLS.Build()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(cfg.ServerName) == 0 {
+		cfg.ServerName = c.Host
+	}
+	return cfg, nil
 }
 
 type PosAutoSaverConfig struct {
-	SaveDir      string
-	SaveInterval time.Duration
+	SaveDir      string        `toml:"save_dir"`
+	SaveInterval time.Duration `toml:"save_interval"`
 }
 
 type HealthCheckerConfig struct {
-	CheckInterval     time.Duration
-	CheckPosThreshold int
+	CheckInterval     time.Duration `toml:"check_interval"`
+	CheckPosThreshold int           `toml:"check_pos_threshold"`
 }
 
 type Config struct {
 	*MySQLConfig
 	*PosAutoSaverConfig
 	*HealthCheckerConfig
+	*ErrorPolicyConfig // 可为nil, 此时OnEvent返回error会直接关闭river
 }
 
 type From string
@@ -0,0 +1,31 @@
+package river
+
+import (
+	"testing"
+)
+
+func TestMySQLConfig_ParseGTIDSet(t *testing.T) {
+	mysqlGTID := "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5"
+	if _, err := (&MySQLConfig{}).ParseGTIDSet(mysqlGTID); err != nil {
+		t.Errorf("default flavor: %s", err)
+	}
+	if _, err := (&MySQLConfig{Flavor: "mariadb"}).ParseGTIDSet("0-1-100"); err != nil {
+		t.Errorf("mariadb: %s", err)
+	}
+	if _, err := (&MySQLConfig{Flavor: "mariadb"}).ParseGTIDSet(mysqlGTID); err == nil {
+		t.Error("mariadb: expect error for mysql gtid")
+	}
+}
+
+func TestMySQLConfig_Check(t *testing.T) {
+	for _, flavor := range []string{"", "mysql", "mariadb"} {
+		if err := (&MySQLConfig{Flavor: flavor}).Check(); err != nil {
+			t.Errorf("%q: %s", flavor, err)
+		}
+	}
+	for _, flavor := range []string{"MySQL", "percona", "mariadb "} {
+		if err := (&MySQLConfig{Flavor: flavor}).Check(); err == nil {
+			t.Errorf("%q: want error", flavor)
+		}
+	}
+}
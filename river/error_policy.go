@@ -0,0 +1,200 @@
+package river
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/juju/errors"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+type ErrorAction string
+
+const (
+	ErrorActionStop       ErrorAction = "stop"        // 关闭river(默认)
+	ErrorActionSkip       ErrorAction = "skip"        // 丢弃该事件, 继续同步
+	ErrorActionDeadLetter ErrorAction = "dead-letter" // 将该事件及错误写入死信, 继续同步
+)
+
+const (
+	deadLetterFileName = "dead_letter.jsonl"
+
+	defaultRetryInterval    = time.Second
+	defaultMaxRetryInterval = 30 * time.Second
+)
+
+// ErrorPolicyConfig OnEvent 返回 error 时的处理策略:
+// 先重试 MaxRetries 次(重试间隔从 RetryInterval 开始翻倍, 不超过 MaxRetryInterval), 仍失败则执行 Action
+type ErrorPolicyConfig struct {
+	MaxRetries       int           `toml:"max_retries"`
+	RetryInterval    time.Duration `toml:"retry_interval"`
+	MaxRetryInterval time.Duration `toml:"max_retry_interval"`
+	Action           ErrorAction   `toml:"action"`
+	DeadLetterDir    string        `toml:"dead_letter_dir"` // 默认死信文件所在目录, 为空时使用 PosAutoSaverConfig.SaveDir
+}
+
+// Retry 按照重试策略执行f, 直到成功、重试次数用尽或ctx结束
+func (c *ErrorPolicyConfig) Retry(ctx context.Context, f func() error) (err error) {
+	interval, maxInterval := c.intervals()
+	for retry := 0; ; retry++ {
+		if err = f(); err == nil || retry >= c.MaxRetries || IsStop(err) {
+			return err
+		}
+		Logger.Warnf("retry %d/%d after %s: %s", retry+1, c.MaxRetries, interval, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(interval):
+		}
+		if interval *= 2; interval > maxInterval {
+			interval = maxInterval
+		}
+	}
+}
+
+// intervals 返回第一次重试的间隔和最大间隔, 最大间隔不小于第一次的间隔
+func (c *ErrorPolicyConfig) intervals() (interval, maxInterval time.Duration) {
+	interval, maxInterval = c.RetryInterval, c.MaxRetryInterval
+	if interval <= 0 {
+		interval = defaultRetryInterval
+	}
+	if maxInterval <= 0 {
+		maxInterval = defaultMaxRetryInterval
+	}
+	if maxInterval < interval {
+		maxInterval = interval
+	}
+	return interval, maxInterval
+}
+
+// DeadLetterSink 保存处理失败的事件
+type DeadLetterSink interface {
+	Write(event *EventData, err error) error
+	Close() error
+}
+
+type deadLetter struct {
+	Error string     `json:"error"`
+	Time  time.Time  `json:"time"`
+	Event *EventData `json:"event"`
+}
+
+// FileDeadLetterSink 以 JSON Lines 的格式将失败事件追加到本地文件
+type FileDeadLetterSink struct {
+	sync.Mutex
+	file *os.File
+}
+
+var _ DeadLetterSink = (*FileDeadLetterSink)(nil)
+
+func NewFileDeadLetterSink(dir string) (*FileDeadLetterSink, error) {
+	if len(dir) == 0 {
+		return nil, emptyDirErr
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Trace(err)
+	}
+	f, err := os.OpenFile(path.Join(dir, deadLetterFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &FileDeadLetterSink{file: f}, nil
+}
+
+func (s *FileDeadLetterSink) Write(event *EventData, err error) error {
+	b, e := json.Marshal(&deadLetter{Error: err.Error(), Time: time.Now(), Event: event})
+	if e != nil {
+		return errors.Trace(e)
+	}
+	s.Lock()
+	defer s.Unlock()
+	if _, e = s.file.Write(append(b, '\n')); e != nil {
+		return errors.Trace(e)
+	}
+	return nil
+}
+
+func (s *FileDeadLetterSink) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	return errors.Trace(s.file.Close())
+}
+
+type errorPolicy struct {
+	config *ErrorPolicyConfig
+	sink   DeadLetterSink
+}
+
+func newErrorPolicy(config *ErrorPolicyConfig, sink DeadLetterSink, saveDir string) (*errorPolicy, error) {
+	// 复制一份, 不修改调用者的配置
+	c := ErrorPolicyConfig{}
+	if config != nil {
+		c = *config
+	}
+	config = &c
+	switch config.Action {
+	case "":
+		config.Action = ErrorActionStop
+	case ErrorActionStop, ErrorActionSkip:
+	case ErrorActionDeadLetter:
+		if sink != nil {
+			break
+		}
+		dir := config.DeadLetterDir
+		if len(dir) == 0 {
+			dir = saveDir
+		}
+		s, err := NewFileDeadLetterSink(dir)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		sink = s
+	default:
+		return nil, fmt.Errorf("unknown error action: %s", config.Action)
+	}
+	return &errorPolicy{config: config, sink: sink}, nil
+}
+
+// handle 执行 handler.OnEvent, 返回的error表示river需要关闭
+func (p *errorPolicy) handle(ctx context.Context, event *EventData, handler Handler) error {
+	err := p.config.Retry(ctx, func() error { return handler.OnEvent(event) })
+	if err == nil || ctx.Err() != nil || IsStop(err) {
+		return err
+	}
+
+	switch p.config.Action {
+	case ErrorActionSkip:
+		Logger.Errorf("skip event at [%s]: %s", event.Position(), err)
+		if discarded := discard(handler, event); len(discarded) != 0 {
+			Logger.Errorf("skip %d buffered events before [%s]", len(discarded), event.Position())
+		}
+		return nil
+	case ErrorActionDeadLetter:
+		Logger.Errorf("dead letter event at [%s]: %s", event.Position(), err)
+		for _, e := range append(discard(handler, event), event) {
+			if e := p.sink.Write(e, err); e != nil {
+				return errors.Annotatef(e, "write dead letter failed, event error: %s", err)
+			}
+		}
+		return nil
+	default:
+		return err
+	}
+}
+
+func discard(handler Handler, event *EventData) []*EventData {
+	if d, ok := handler.(Discarder); ok {
+		return d.Discard(event)
+	}
+	return nil
+}
+
+func (p *errorPolicy) Close() error {
+	if p.sink == nil {
+		return nil
+	}
+	return errors.Trace(p.sink.Close())
+}
@@ -0,0 +1,175 @@
+package river
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestErrorPolicyConfig_Retry(t *testing.T) {
+	config := &ErrorPolicyConfig{MaxRetries: 2, RetryInterval: time.Millisecond}
+
+	times := 0
+	err := config.Retry(context.Background(), func() error {
+		times++
+		return fmt.Errorf("failed")
+	})
+	if err == nil || times != 3 {
+		t.Fatalf("expect 3 calls and an error, got %d calls, err: %v", times, err)
+	}
+
+	times = 0
+	err = config.Retry(context.Background(), func() error {
+		if times++; times < 2 {
+			return fmt.Errorf("failed")
+		}
+		return nil
+	})
+	if err != nil || times != 2 {
+		t.Fatalf("expect success on the 2nd call, got %d calls, err: %v", times, err)
+	}
+}
+
+func TestErrorPolicyConfig_Intervals(t *testing.T) {
+	for _, c := range []struct {
+		config            ErrorPolicyConfig
+		interval, maxInterval time.Duration
+	}{
+		{ErrorPolicyConfig{}, time.Second, 30 * time.Second},
+		{ErrorPolicyConfig{RetryInterval: 2 * time.Second, MaxRetryInterval: 10 * time.Second}, 2 * time.Second, 10 * time.Second},
+		{ErrorPolicyConfig{RetryInterval: time.Minute}, time.Minute, time.Minute},
+		{ErrorPolicyConfig{RetryInterval: time.Minute, MaxRetryInterval: time.Second}, time.Minute, time.Minute},
+	} {
+		interval, maxInterval := c.config.intervals()
+		if interval != c.interval || maxInterval != c.maxInterval {
+			t.Errorf("%+v: got %s %s, want %s %s", c.config, interval, maxInterval, c.interval, c.maxInterval)
+		}
+	}
+}
+
+func TestNewErrorPolicy_DefaultAction(t *testing.T) {
+	config := &ErrorPolicyConfig{MaxRetries: 1}
+	policy, err := newErrorPolicy(config, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.config.Action != ErrorActionStop || len(config.Action) != 0 {
+		t.Errorf("policy action %s, config action %q", policy.config.Action, config.Action)
+	}
+}
+
+func TestErrorPolicy_Handle(t *testing.T) {
+	event := &EventData{EventType: EventTypeInsert, LogName: "mysql-bin.000001", LogPos: 4}
+	onEvent := func(*EventData) error { return fmt.Errorf("malformed row") }
+
+	stop, err := newErrorPolicy(nil, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stop.handle(context.Background(), event, NopCloserAlerter(onEvent)); err == nil {
+		t.Fatal("expect stop policy returns error")
+	}
+
+	skip, err := newErrorPolicy(&ErrorPolicyConfig{Action: ErrorActionSkip}, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := skip.handle(context.Background(), event, NopCloserAlerter(onEvent)); err != nil {
+		t.Fatalf("expect skip policy swallows error, got %v", err)
+	}
+
+	dir := t.TempDir()
+	policy, err := newErrorPolicy(&ErrorPolicyConfig{Action: ErrorActionDeadLetter}, nil, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := policy.handle(context.Background(), event, NopCloserAlerter(onEvent)); err != nil {
+		t.Fatalf("expect dead letter policy swallows error, got %v", err)
+	}
+	if err := policy.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path.Join(dir, deadLetterFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		t.Fatal("dead letter file is empty")
+	}
+	var got deadLetter
+	if err := json.Unmarshal(scanner.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Error != "malformed row" || got.Event.Position() != event.Position() {
+		t.Fatalf("unexpected dead letter: %+v", got)
+	}
+}
+
+// bufferHandler 缓存行变更, 收到xid时处理失败
+type bufferHandler struct {
+	NopCloserAlerter
+	events []*EventData
+}
+
+func (h *bufferHandler) OnEvent(event *EventData) error {
+	if event.EventType != EventTypeXID {
+		h.events = append(h.events, event)
+		return nil
+	}
+	return fmt.Errorf("send failed")
+}
+
+func (h *bufferHandler) Discard(*EventData) []*EventData {
+	events := h.events
+	h.events = nil
+	return events
+}
+
+type memorySink struct {
+	events []*EventData
+}
+
+func (s *memorySink) Write(event *EventData, err error) error {
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *memorySink) Close() error { return nil }
+
+func TestErrorPolicy_HandleDiscard(t *testing.T) {
+	sink := &memorySink{}
+	policy, err := newErrorPolicy(&ErrorPolicyConfig{Action: ErrorActionDeadLetter}, sink, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &bufferHandler{}
+	events := []*EventData{
+		{EventType: EventTypeInsert, LogName: "mysql-bin.000001", LogPos: 100},
+		{EventType: EventTypeInsert, LogName: "mysql-bin.000001", LogPos: 200},
+		{EventType: EventTypeXID, LogName: "mysql-bin.000001", LogPos: 300},
+	}
+	for _, event := range events {
+		if err := policy.handle(context.Background(), event, h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(h.events) != 0 {
+		t.Errorf("buffer not discarded: %d events", len(h.events))
+	}
+	if len(sink.events) != 3 {
+		t.Fatalf("dead letters: got %d events, want 3", len(sink.events))
+	}
+	for i, event := range sink.events {
+		if event != events[i] {
+			t.Errorf("dead letter %d: %s, want %s", i, event.Position(), events[i].Position())
+		}
+	}
+}
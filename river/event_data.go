@@ -1,10 +1,15 @@
 package river
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"github.com/go-mysql-org/go-mysql/canal"
+	"github.com/go-mysql-org/go-mysql/schema"
 	"github.com/juju/errors"
+	"strconv"
+	"strings"
 )
 
 const (
@@ -31,9 +36,82 @@ type EventData struct {
 	Primary   []string               `json:"primary"`   // 主键字段；EventType为insert、update、delete时有值
 	Before    map[string]interface{} `json:"before"`    // 变更前数据, insert 类型的 before 为空
 	After     map[string]interface{} `json:"after"`     // 变更后数据, delete 类型的 after 为空
+	Columns   []*Column              `json:"columns"`   // 表字段定义, 按表定义的顺序；EventType为insert、update、delete时有值
 	Timestamp uint32                 `json:"timestamp"` // 事件时间
 }
 
+const (
+	ColumnTypeNumber    = "number"    // tinyint, smallint, int, bigint, year
+	ColumnTypeMediumInt = "mediumint" // mediumint
+	ColumnTypeFloat     = "float"     // float, double
+	ColumnTypeDecimal   = "decimal"   // decimal
+	ColumnTypeEnum      = "enum"      // enum
+	ColumnTypeSet       = "set"       // set
+	ColumnTypeString    = "string"    // char, varchar, text, blob
+	ColumnTypeBinary    = "binary"    // binary, varbinary
+	ColumnTypeDatetime  = "datetime"  // datetime
+	ColumnTypeTimestamp = "timestamp" // timestamp
+	ColumnTypeDate      = "date"      // date
+	ColumnTypeTime      = "time"      // time
+	ColumnTypeBit       = "bit"       // bit
+	ColumnTypeJSON      = "json"      // json
+	ColumnTypePoint     = "point"     // point
+)
+
+var columnTypes = map[int]string{
+	schema.TYPE_NUMBER:     ColumnTypeNumber,
+	schema.TYPE_MEDIUM_INT: ColumnTypeMediumInt,
+	schema.TYPE_FLOAT:      ColumnTypeFloat,
+	schema.TYPE_DECIMAL:    ColumnTypeDecimal,
+	schema.TYPE_ENUM:       ColumnTypeEnum,
+	schema.TYPE_SET:        ColumnTypeSet,
+	schema.TYPE_STRING:     ColumnTypeString,
+	schema.TYPE_BINARY:     ColumnTypeBinary,
+	schema.TYPE_DATETIME:   ColumnTypeDatetime,
+	schema.TYPE_TIMESTAMP:  ColumnTypeTimestamp,
+	schema.TYPE_DATE:       ColumnTypeDate,
+	schema.TYPE_TIME:       ColumnTypeTime,
+	schema.TYPE_BIT:        ColumnTypeBit,
+	schema.TYPE_JSON:       ColumnTypeJSON,
+	schema.TYPE_POINT:      ColumnTypePoint,
+}
+
+type Column struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`     // ColumnTypeXXX
+	RawType  string `json:"raw_type"` // 建表语句中的类型, 如 varchar(255)、bigint(20) unsigned
+	Unsigned bool   `json:"unsigned"`
+}
+
+// IsBinary 字段值是否为二进制数据(binary、varbinary、blob、geometry)
+func (c *Column) IsBinary() bool {
+	return c.Type == ColumnTypeBinary || c.Type == ColumnTypePoint ||
+		strings.HasSuffix(c.RawType, "blob") || strings.HasPrefix(c.RawType, "geometry")
+}
+
+func buildColumns(columns []schema.TableColumn) []*Column {
+	res := make([]*Column, 0, len(columns))
+	for _, c := range columns {
+		res = append(res, &Column{
+			Name:     c.Name,
+			Type:     columnTypes[c.Type],
+			RawType:  c.RawType,
+			Unsigned: c.IsUnsigned,
+		})
+	}
+	return res
+}
+
+// Column 返回字段定义, 不存在时返回nil
+func (e *EventData) Column(name string) *Column {
+	for _, c := range e.Columns {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
 func (e *EventData) Position() string {
 	return fmt.Sprintf("%s:%d", e.LogName, e.LogPos)
 }
@@ -45,3 +123,62 @@ func Event2Bytes(e *EventData) ([]byte, error) {
 	}
 	return b, nil
 }
+
+// Bytes2Event 将 Event2Bytes 的结果还原为 EventData. json 会丢失字段值的类型, 按照 Columns 还原:
+// 整数为 int64(unsigned 为 uint64), 浮点数为 float64, 二进制数据和json字段为 []byte, text 为 string, 其余保持 json 中的类型
+func Bytes2Event(b []byte) (*EventData, error) {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	e := &EventData{}
+	if err := decoder.Decode(e); err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, row := range []map[string]interface{}{e.Before, e.After} {
+		for field, value := range row {
+			v, err := normalizeValue(e.Column(field), value)
+			if err != nil {
+				return nil, errors.Annotatef(err, "field %s", field)
+			}
+			row[field] = v
+		}
+	}
+	return e, nil
+}
+
+func normalizeValue(column *Column, value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case json.Number:
+		if column == nil {
+			if i, err := v.Int64(); err == nil {
+				return i, nil
+			}
+			return v.Float64()
+		}
+		switch column.Type {
+		case ColumnTypeFloat:
+			return v.Float64()
+		case ColumnTypeDecimal:
+			return v.String(), nil
+		}
+		if column.Unsigned {
+			return strconv.ParseUint(v.String(), 10, 64)
+		}
+		return v.Int64()
+	case string:
+		// []byte 在 json 中为 base64 编码的字符串
+		if column == nil {
+			return v, nil
+		}
+		if column.IsBinary() || column.Type == ColumnTypeJSON {
+			return base64.StdEncoding.DecodeString(v)
+		}
+		if strings.HasSuffix(column.RawType, "text") {
+			b, err := base64.StdEncoding.DecodeString(v)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			return string(b), nil
+		}
+	}
+	return value, nil
+}
@@ -0,0 +1,82 @@
+package river
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBytes2Event(t *testing.T) {
+	event := &EventData{
+		EventType: EventTypeUpdate,
+		Db:        "shop",
+		Table:     "user",
+		Primary:   []string{"id"},
+		Columns: []*Column{
+			{Name: "id", Type: ColumnTypeNumber, RawType: "bigint(20) unsigned", Unsigned: true},
+			{Name: "age", Type: ColumnTypeNumber, RawType: "int(11)"},
+			{Name: "score", Type: ColumnTypeFloat, RawType: "double"},
+			{Name: "price", Type: ColumnTypeDecimal, RawType: "decimal(10,2)"},
+			{Name: "name", Type: ColumnTypeString, RawType: "varchar(255)"},
+			{Name: "bio", Type: ColumnTypeString, RawType: "text"},
+			{Name: "avatar", Type: ColumnTypeString, RawType: "blob"},
+			{Name: "extra", Type: ColumnTypeJSON, RawType: "json"},
+			{Name: "created", Type: ColumnTypeDatetime, RawType: "datetime"},
+		},
+		Before: map[string]interface{}{"id": uint64(18446744073709551615), "age": int32(-3), "name": nil},
+		After: map[string]interface{}{
+			"id":      uint64(18446744073709551615),
+			"age":     int32(18),
+			"score":   99.5,
+			"price":   "12.30",
+			"name":    "lihua",
+			"bio":     []byte("hello"),
+			"avatar":  []byte{0xff, 0x00},
+			"extra":   []byte(`{"a":1}`),
+			"created": "2023-02-05 21:27:45",
+		},
+	}
+	b, err := Event2Bytes(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Bytes2Event(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantBefore := map[string]interface{}{"id": uint64(18446744073709551615), "age": int64(-3), "name": nil}
+	wantAfter := map[string]interface{}{
+		"id":      uint64(18446744073709551615),
+		"age":     int64(18),
+		"score":   99.5,
+		"price":   "12.30",
+		"name":    "lihua",
+		"bio":     "hello",
+		"avatar":  []byte{0xff, 0x00},
+		"extra":   []byte(`{"a":1}`),
+		"created": "2023-02-05 21:27:45",
+	}
+	if !reflect.DeepEqual(got.Before, wantBefore) {
+		t.Errorf("before: got %#v, want %#v", got.Before, wantBefore)
+	}
+	if !reflect.DeepEqual(got.After, wantAfter) {
+		t.Errorf("after: got %#v, want %#v", got.After, wantAfter)
+	}
+	if got.Db != "shop" || got.Table != "user" || !reflect.DeepEqual(got.Columns, event.Columns) {
+		t.Errorf("unexpected event: %+v", got)
+	}
+}
+
+func TestBytes2Event_WithoutColumns(t *testing.T) {
+	got, err := Bytes2Event([]byte(`{"event_type":"insert","after":{"id":1,"rate":0.5,"name":"x"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"id": int64(1), "rate": 0.5, "name": "x"}
+	if !reflect.DeepEqual(got.After, want) {
+		t.Errorf("got %#v, want %#v", got.After, want)
+	}
+	if _, err := Bytes2Event([]byte("not json")); err == nil {
+		t.Error("expect error for invalid json")
+	}
+}
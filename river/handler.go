@@ -1,5 +1,17 @@
 package river
 
+import (
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/juju/errors"
+)
+
+// ErrStop Handler 的 OnEvent 返回 ErrStop 时, river 不再继续解析并正常关闭(River.Error 为 nil)
+var ErrStop = errors.New("stop river")
+
+func IsStop(err error) bool {
+	return err != nil && errors.Cause(err) == ErrStop
+}
+
 type Handler interface {
 	String() string
 	OnEvent(event *EventData) error
@@ -7,6 +19,20 @@ type Handler interface {
 	OnClose(river *River) // OnEvent、OnAlert抛出的error会触发OnClose
 }
 
+// Committer 由异步处理 event 的 Handler 实现(如批量发送到kafka), river 只保存 Handler 确认处理完成的位置,
+// 重启后从该位置继续解析, 未确认的 event 不会丢失.
+type Committer interface {
+	// Committed 返回可以保存的位置, handled 为已经交给 OnEvent 的最新位置.
+	// 没有未确认的 event 时返回 handled, 返回空的 Name 表示暂时不能保存
+	Committed(handled mysql.Position) mysql.Position
+}
+
+// Discarder 由缓存 event 的 Handler 实现(如按事务批量发送到kafka). ErrorPolicy 重试失败后跳过 event 或写入死信时调用 Discard,
+// Handler 丢弃缓存中与该 event 一起处理的 event 并返回它们, 写入死信时这些 event 和该 event 一起写入
+type Discarder interface {
+	Discard(event *EventData) []*EventData
+}
+
 type NopCloserAlerter func(event *EventData) error
 
 func (f NopCloserAlerter) OnAlert(*StatusMsg) error       { return nil }
@@ -30,6 +30,7 @@ type masterInfo struct {
 	sync.RWMutex        // protect below
 	Name         string `toml:"bin_name"`
 	Pos          uint32 `toml:"bin_pos"`
+	GTID         string `toml:"bin_gtid,omitempty"` // 手动设置的GTID起始位置, river保存新的position后清空
 	filePath     string
 	lastSaveTime time.Time
 	saveInterval time.Duration
@@ -70,6 +71,12 @@ func (m *masterInfo) position() mysql.Position {
 	return pos
 }
 
+func (m *masterInfo) gtid() string {
+	m.RLock()
+	defer m.RUnlock()
+	return m.GTID
+}
+
 func (m *masterInfo) Close() error {
 	pos := m.position()
 	err := m.save(pos.Name, pos.Pos)
@@ -98,7 +105,26 @@ func (m *masterInfo) save(name string, pos uint32) error {
 	m.lastSaveTime = n
 	m.Name = name
 	m.Pos = pos
+	m.GTID = ""
+	return errors.Trace(m.flush())
+}
+
+// reset 忽略保存间隔, 直接覆盖保存的位置
+func (m *masterInfo) reset(name string, pos uint32, gtid string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.filePath == "" {
+		return emptyPathErr
+	}
+	m.lastSaveTime = time.Now()
+	m.Name = name
+	m.Pos = pos
+	m.GTID = gtid
+	return errors.Trace(m.flush())
+}
 
+func (m *masterInfo) flush() error {
 	var buf bytes.Buffer
 	if err := toml.NewEncoder(&buf).Encode(m); err != nil {
 		return errors.Trace(err)
@@ -0,0 +1,129 @@
+package river
+
+import (
+	"fmt"
+	"github.com/go-mysql-org/go-mysql/client"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/juju/errors"
+	"strconv"
+	"strings"
+)
+
+// ParsePosition 解析 mysql-bin.000001:4 格式的position
+func ParsePosition(s string) (mysql.Position, error) {
+	idx := strings.LastIndex(s, ":")
+	if idx <= 0 {
+		return mysql.Position{}, fmt.Errorf("invalid position %q, expect file:pos", s)
+	}
+	pos, err := strconv.ParseUint(s[idx+1:], 10, 32)
+	if err != nil {
+		return mysql.Position{}, fmt.Errorf("invalid position %q, expect file:pos", s)
+	}
+	return mysql.Position{Name: s[:idx], Pos: uint32(pos)}, nil
+}
+
+// ReadFilePosition 读取 master.info 中保存的 position 和手动设置的 GTID
+func ReadFilePosition(config *PosAutoSaverConfig) (mysql.Position, string, error) {
+	m, err := loadMasterInfo(config.SaveDir, config.SaveInterval)
+	if err != nil {
+		return mysql.Position{}, "", errors.Trace(err)
+	}
+	return m.position(), m.gtid(), nil
+}
+
+// WriteFilePosition 覆盖 master.info 中保存的位置. river 以 FromFile 启动时:
+// gtid 不为空则从 gtid 开始解析, 否则从 pos 开始解析.
+// 修改时 river 必须处于停止状态, 否则会被运行中的 river 覆盖.
+func WriteFilePosition(config *PosAutoSaverConfig, pos mysql.Position, gtid string) error {
+	m, err := loadMasterInfo(config.SaveDir, config.SaveInterval)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(m.reset(pos.Name, pos.Pos, gtid))
+}
+
+// ReadDBPosition 读取 mysql 当前的 binlog position 和已执行的 GTID 集合
+func ReadDBPosition(config *MySQLConfig) (pos mysql.Position, gtid string, err error) {
+	conn, err := connect(config)
+	if err != nil {
+		return pos, "", errors.Trace(err)
+	}
+	defer conn.Close()
+
+	rr, err := conn.Execute("SHOW MASTER STATUS")
+	if err != nil {
+		return pos, "", errors.Trace(err)
+	}
+	if rr.RowNumber() == 0 {
+		return pos, "", fmt.Errorf("binlog is not enabled")
+	}
+	pos.Name, _ = rr.GetString(0, 0)
+	p, _ := rr.GetInt(0, 1)
+	pos.Pos = uint32(p)
+	if config.flavor() == mysql.MariaDBFlavor {
+		if rr, err = conn.Execute("SELECT @@GLOBAL.gtid_binlog_pos"); err != nil {
+			return pos, "", errors.Trace(err)
+		}
+		gtid, _ = rr.GetString(0, 0)
+	} else if rr.ColumnNumber() > 4 {
+		gtid, _ = rr.GetString(0, 4)
+	}
+	return pos, gtid, nil
+}
+
+// BinlogLag 计算 pos 落后 mysql 当前 binlog position 的字节数(跨binlog文件时累加中间文件的大小)
+func BinlogLag(config *MySQLConfig, pos mysql.Position) (lag int64, dbPos mysql.Position, err error) {
+	conn, err := connect(config)
+	if err != nil {
+		return 0, dbPos, errors.Trace(err)
+	}
+	defer conn.Close()
+
+	rr, err := conn.Execute("SHOW BINARY LOGS")
+	if err != nil {
+		return 0, dbPos, errors.Trace(err)
+	}
+	found := false
+	for i := 0; i < rr.RowNumber(); i++ {
+		name, _ := rr.GetString(i, 0)
+		size, _ := rr.GetInt(i, 1)
+		if name == pos.Name {
+			found = true
+			lag -= int64(pos.Pos)
+		}
+		if found {
+			lag += size
+		}
+		dbPos = mysql.Position{Name: name, Pos: uint32(size)}
+	}
+	if !found {
+		return 0, dbPos, fmt.Errorf("binlog %s not found, it may have been purged", pos.Name)
+	}
+	return lag, dbPos, nil
+}
+
+func connect(config *MySQLConfig) (*client.Conn, error) {
+	if err := config.Check(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	tlsConfig, err := config.tlsConfig()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	addr := fmt.Sprintf("%s:%d", config.Host, config.Port)
+	conn, err := client.Connect(addr, config.User, config.Password, "", func(conn *client.Conn) {
+		if tlsConfig != nil {
+			conn.SetTLSConfig(tlsConfig)
+		}
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(config.Charset) != 0 {
+		if err := conn.SetCharset(config.Charset); err != nil {
+			conn.Close()
+			return nil, errors.Trace(err)
+		}
+	}
+	return conn, nil
+}
@@ -0,0 +1,38 @@
+package river
+
+import (
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"testing"
+)
+
+func TestParsePosition(t *testing.T) {
+	pos, err := ParsePosition("mysql-bin.000003:1234")
+	if err != nil || pos.Name != "mysql-bin.000003" || pos.Pos != 1234 {
+		t.Fatalf("unexpected position: %+v, err: %v", pos, err)
+	}
+	for _, s := range []string{"", "mysql-bin.000003", ":4", "mysql-bin.000003:abc"} {
+		if _, err := ParsePosition(s); err == nil {
+			t.Errorf("expect error for %q", s)
+		}
+	}
+}
+
+func TestWriteFilePosition(t *testing.T) {
+	config := &PosAutoSaverConfig{SaveDir: t.TempDir()}
+	want := mysql.Position{Name: "mysql-bin.000003", Pos: 1234}
+	if err := WriteFilePosition(config, want, ""); err != nil {
+		t.Fatal(err)
+	}
+	pos, gtid, err := ReadFilePosition(config)
+	if err != nil || pos != want || gtid != "" {
+		t.Fatalf("unexpected position: %+v %q, err: %v", pos, gtid, err)
+	}
+
+	wantGTID := "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5"
+	if err := WriteFilePosition(config, mysql.Position{}, wantGTID); err != nil {
+		t.Fatal(err)
+	}
+	if _, gtid, err = ReadFilePosition(config); err != nil || gtid != wantGTID {
+		t.Fatalf("unexpected gtid: %q, err: %v", gtid, err)
+	}
+}
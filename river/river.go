@@ -8,6 +8,7 @@ import (
 	"github.com/go-mysql-org/go-mysql/replication"
 	"github.com/go-mysql-org/go-mysql/schema"
 	"github.com/juju/errors"
+	"sync"
 	"time"
 )
 
@@ -20,14 +21,22 @@ type River struct {
 	nextLog     string
 	nextPos     uint32
 
-	masterInfo *masterInfo // 记录解析到哪了
-	healthInfo *healthInfo // 记录masterInfo和canal.GetMasterPos()的差距,可对接告警机制
-	canal      *canal.Canal
+	handledLock sync.Mutex
+	handledPos  mysql.Position // 已经交给 Handler 的最新位置
+
+	masterInfo  *masterInfo  // 记录解析到哪了
+	healthInfo  *healthInfo  // 记录masterInfo和canal.GetMasterPos()的差距,可对接告警机制
+	errorPolicy *errorPolicy // OnEvent返回error时的重试、跳过、死信策略
+	canal       *canal.Canal
+
+	deadLetterSink DeadLetterSink
 
 	Error error
 
-	ctx    context.Context
-	cancel context.CancelFunc
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+	done      chan struct{} // Close 执行完成后关闭
 
 	syncChan   chan *EventData
 	statusChan chan *StatusMsg
@@ -44,16 +53,27 @@ func (r *River) SetHandler(handler Handler) *River {
 	return r
 }
 
+// SetDeadLetterSink 替换默认的死信文件, 仅在 ErrorPolicyConfig.Action 为 ErrorActionDeadLetter 时生效
+func (r *River) SetDeadLetterSink(sink DeadLetterSink) *River {
+	r.deadLetterSink = sink
+	return r
+}
+
 func (r *River) PrintConfig(from From) {
-	fmt.Println(logo)
+	fmt.Print(logo)
 	temp := *r.config.MySQLConfig
 	temp.Password = ""
+	var errorPolicy ErrorPolicyConfig
+	if r.config.ErrorPolicyConfig != nil {
+		errorPolicy = *r.config.ErrorPolicyConfig
+	}
 	fmt.Printf(`
 Handler            :  %+v
 From               :  %+v
 MySQLConfig        :  %+v
 PosAutoSaverConfig :  %+v
 HealthCheckerConfig:  %+v
+ErrorPolicyConfig  :  %+v
 --------------------
 `,
 		r.handler.String(),
@@ -61,6 +81,7 @@ HealthCheckerConfig:  %+v
 		temp,
 		*r.config.PosAutoSaverConfig,
 		*r.config.HealthCheckerConfig,
+		errorPolicy,
 	)
 }
 
@@ -71,21 +92,52 @@ func (r *River) Sync(from From) (err error) {
 		return errors.Trace(err)
 	}
 
+	// 通过 WriteFilePosition 手动设置了GTID, 从GTID开始解析
+	if gtid := r.masterInfo.gtid(); from == FromFile && len(gtid) != 0 {
+		gset, err := r.config.MySQLConfig.ParseGTIDSet(gtid)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		go r.loopSync(mysql.Position{}, r.handler)
+		go r.loopHealthCheck(r.handler.OnAlert)
+		r.canal.SetEventHandler(r)
+		return r.wait(r.canal.StartFromGTID(gset))
+	}
+
 	startPos := r.GetFilePosition()
 	if from == FromDB || len(startPos.Name) == 0 || startPos.Pos == 0 {
 		if startPos, err = r.GetDBPosition(); err != nil {
 			return errors.Trace(err)
 		}
 	}
+	return r.run(startPos)
+}
+
+// SyncFrom 忽略 master.info, 从指定的 position 开始解析
+func (r *River) SyncFrom(pos mysql.Position) error {
+	r.PrintConfig(From(fmt.Sprintf("%s:%d", pos.Name, pos.Pos)))
+
+	if err := r.prepare(); err != nil {
+		return errors.Trace(err)
+	}
+	return r.run(pos)
+}
 
-	go r.loopSync(startPos, r.handler.OnEvent)
+func (r *River) run(startPos mysql.Position) error {
+	go r.loopSync(startPos, r.handler)
 	go r.loopHealthCheck(r.handler.OnAlert)
 
 	r.canal.SetEventHandler(r)
-	if err := r.canal.RunFrom(startPos); err != nil {
-		return errors.Trace(err)
+	return r.wait(r.canal.RunFrom(startPos))
+}
+
+// wait canal 返回后等待 Close 执行完成, 返回 River.Error. canal 出错返回时以该error关闭river
+func (r *River) wait(canalErr error) error {
+	if canalErr != nil {
+		r.Close(errors.Trace(canalErr))
 	}
-	return nil
+	<-r.done
+	return errors.Trace(r.Error)
 }
 
 func (r *River) prepare() (err error) {
@@ -93,7 +145,7 @@ func (r *River) prepare() (err error) {
 	saver := r.config.PosAutoSaverConfig
 	checker := r.config.HealthCheckerConfig
 
-	r.canal, err = newCanal(db.Host, db.Port, db.User, db.Password)
+	r.canal, err = newCanal(db)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -102,7 +154,12 @@ func (r *River) prepare() (err error) {
 		return errors.Trace(err)
 	}
 	r.healthInfo = newHealthInfo(checker.CheckInterval, checker.CheckPosThreshold)
+	r.errorPolicy, err = newErrorPolicy(r.config.ErrorPolicyConfig, r.deadLetterSink, saver.SaveDir)
+	if err != nil {
+		return errors.Trace(err)
+	}
 	r.ctx, r.cancel = context.WithCancel(context.Background())
+	r.done = make(chan struct{})
 	r.syncChan = make(chan *EventData, 4094)
 	r.statusChan = make(chan *StatusMsg, 64)
 	return nil
@@ -244,6 +301,7 @@ func (r *River) OnRow(e *canal.RowsEvent) error {
 		Primary:   primaryKey,
 		Before:    before,
 		After:     after,
+		Columns:   buildColumns(e.Table.Columns),
 		Timestamp: e.Header.Timestamp,
 	}
 	return nil
@@ -273,13 +331,50 @@ func (r *River) OnPosSynced(*replication.EventHeader, mysql.Position, mysql.GTID
 	return nil
 }
 
+// Close 只执行一次, 之后的调用等待第一次调用执行完成后返回
 func (r *River) Close(err error) {
-	Logger.Info("closing river")
-	r.canal.Close()
-	r.masterInfo.Close()
-	r.cancel()
-	r.Error = err
-	r.handler.OnClose(r)
+	r.closeOnce.Do(func() {
+		Logger.Info("closing river")
+		r.Error = err
+		r.canal.Close()
+		r.masterInfo.Close()
+		r.errorPolicy.Close()
+		r.cancel()
+		r.handler.OnClose(r)
+		r.saveCommittedPos()
+		close(r.done)
+	})
+}
+
+func (r *River) setHandledPos(name string, pos uint32) {
+	r.handledLock.Lock()
+	defer r.handledLock.Unlock()
+	r.handledPos = mysql.Position{Name: name, Pos: pos}
+}
+
+// committedPos 返回可以保存的位置, Handler 实现了 Committer 时只保存其确认的位置
+func (r *River) committedPos() mysql.Position {
+	r.handledLock.Lock()
+	pos := r.handledPos
+	r.handledLock.Unlock()
+	if c, ok := r.handler.(Committer); ok && len(pos.Name) != 0 {
+		return c.Committed(pos)
+	}
+	return pos
+}
+
+// saveCommittedPos Handler 在 OnClose 中确认剩余的 event 后保存最终的位置
+func (r *River) saveCommittedPos() {
+	if _, ok := r.handler.(Committer); !ok {
+		return
+	}
+	pos := r.committedPos()
+	if len(pos.Name) == 0 {
+		return
+	}
+	if err := r.masterInfo.reset(pos.Name, pos.Pos, ""); err != nil {
+		Logger.Errorf("save committed position error: %s", errors.ErrorStack(err))
+	}
 }
 
 // To avoid false alarms, need to sleep for a period of time, then take the result again and compare it again
@@ -366,7 +461,7 @@ func (r *River) loopHealthCheck(onAlert func(msg *StatusMsg) error) {
 	}
 }
 
-func (r *River) loopSync(startPos mysql.Position, onEvent func(event *EventData) error) {
+func (r *River) loopSync(startPos mysql.Position, handler Handler) {
 	ticker := time.NewTicker(r.masterInfo.saveInterval)
 	defer ticker.Stop()
 
@@ -384,27 +479,50 @@ func (r *River) loopSync(startPos mysql.Position, onEvent func(event *EventData)
 			if event.EventType == EventTypeRotate || event.EventType == EventTypeDDL {
 				needSavePos = true
 			}
-			if err := onEvent(event); err != nil {
+			if err := r.errorPolicy.handle(r.ctx, event, handler); IsStop(err) {
+				r.Close(nil)
+			} else if err != nil {
 				r.Close(err)
 			}
 		}
 
-		if needSavePos {
-			Logger.Debugf("position auto save at: [%s:%d]", logName, logPas)
-			if err := r.masterInfo.save(logName, logPas); err != nil {
+		r.setHandledPos(logName, logPas)
+		if !needSavePos {
+			continue
+		}
+		pos := r.committedPos()
+		if len(pos.Name) != 0 {
+			Logger.Debugf("position auto save at: [%s:%d]", pos.Name, pos.Pos)
+			if err := r.masterInfo.save(pos.Name, pos.Pos); err != nil {
 				r.Close(err) // 无法正常写入,直接退出
 			}
 		}
 	}
 }
 
-func newCanal(host string, port int64, user string, password string) (*canal.Canal, error) {
+func newCanal(db *MySQLConfig) (*canal.Canal, error) {
+	if err := db.Check(); err != nil {
+		return nil, errors.Trace(err)
+	}
 	cfg := canal.NewDefaultConfig()
-	cfg.Addr = fmt.Sprintf("%s:%d", host, port)
-	cfg.User = user
-	cfg.Password = password
-	cfg.Flavor = "mysql"
+	cfg.Addr = fmt.Sprintf("%s:%d", db.Host, db.Port)
+	cfg.User = db.User
+	cfg.Password = db.Password
+	cfg.Flavor = db.flavor()
+	cfg.HeartbeatPeriod = db.HeartbeatPeriod
+	cfg.ReadTimeout = db.ReadTimeout
 	cfg.Dump.ExecutionPath = ""
+	if db.ServerID != 0 {
+		cfg.ServerID = db.ServerID
+	}
+	if len(db.Charset) != 0 {
+		cfg.Charset = db.Charset
+	}
+	tlsConfig, err := db.tlsConfig()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	cfg.TLSConfig = tlsConfig
 
 	c, err := canal.NewCanal(cfg)
 	if err != nil {
@@ -0,0 +1,173 @@
+package river
+
+import (
+	"fmt"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/juju/errors"
+	"path"
+	"strings"
+)
+
+// Router 按照 db.table 规则将事件分发给不同的 Handler, 多个 Handler 共用同一个binlog流.
+// 规则使用 path.Match 语法, 如 "orders.*"、"*.user"、"*.*", 省略表名时等同于 "db.*".
+// ddl 分发给库名匹配的 Handler; gtid、xid、rotate 不属于任何表, 分发给所有 Handler.
+// 某个 Handler 处理失败后, ErrorPolicy 重试该事件时只交给还没有处理成功的 Handler.
+// Router example:
+//
+//	router := river.NewRouter().
+//		Route(kafkaBroker, "orders.*").
+//		Route(esHandler, "testdb01.user").
+//		Route(traceLogHandler, "*.*")
+//	err := river.New(config).SetHandler(router).Sync(river.FromFile)
+type Router struct {
+	routes []*route
+
+	// 最后一个处理失败的 event 和其中已经处理成功的 route, ErrorPolicy 重试该 event 时跳过这些 route, 避免重复处理
+	failed  *EventData
+	handled map[int]bool
+}
+
+type route struct {
+	handler  Handler
+	patterns [][2]string // [db, table]
+}
+
+var (
+	_ Handler   = (*Router)(nil)
+	_ Committer = (*Router)(nil)
+	_ Discarder = (*Router)(nil)
+)
+
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Route 将匹配任一 patterns 的事件交给 handler, 同一个事件只会分发一次; 不指定 patterns 时匹配所有表.
+// pattern 不合法时 panic, 来自配置的 pattern 应该先用 CheckRoutePattern 检查
+func (r *Router) Route(handler Handler, patterns ...string) *Router {
+	if len(patterns) == 0 {
+		patterns = []string{"*.*"}
+	}
+	rt := &route{handler: handler}
+	for _, pattern := range patterns {
+		db, table, err := parseRoutePattern(pattern)
+		if err != nil {
+			panic(err.Error())
+		}
+		rt.patterns = append(rt.patterns, [2]string{db, table})
+	}
+	r.routes = append(r.routes, rt)
+	return r
+}
+
+// CheckRoutePattern 检查 Route 的 pattern 是否合法
+func CheckRoutePattern(pattern string) error {
+	_, _, err := parseRoutePattern(pattern)
+	return err
+}
+
+func parseRoutePattern(pattern string) (db, table string, err error) {
+	db, table = pattern, "*"
+	if idx := strings.Index(pattern, "."); idx >= 0 {
+		db, table = pattern[:idx], pattern[idx+1:]
+	}
+	for _, p := range []string{db, table} {
+		if _, err := path.Match(p, ""); err != nil {
+			return "", "", fmt.Errorf("invalid route pattern %q: %s", pattern, err)
+		}
+	}
+	return db, table, nil
+}
+
+func (rt *route) match(event *EventData) bool {
+	if len(event.Db) == 0 {
+		return true
+	}
+	for _, p := range rt.patterns {
+		if ok, _ := path.Match(p[0], event.Db); !ok {
+			continue
+		}
+		if len(event.Table) == 0 {
+			return true
+		}
+		if ok, _ := path.Match(p[1], event.Table); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Router) String() string {
+	names := make([]string, 0, len(r.routes))
+	for _, rt := range r.routes {
+		names = append(names, rt.handler.String())
+	}
+	return fmt.Sprintf("router(%s)", strings.Join(names, ", "))
+}
+
+func (r *Router) OnEvent(event *EventData) error {
+	if event != r.failed {
+		r.failed, r.handled = nil, nil
+	}
+	for i, rt := range r.routes {
+		if !rt.match(event) || r.handled[i] {
+			continue
+		}
+		if err := rt.handler.OnEvent(event); err != nil {
+			r.failed = event
+			return errors.Annotatef(err, "handler %s", rt.handler.String())
+		}
+		if r.handled == nil {
+			r.handled = make(map[int]bool)
+		}
+		r.handled[i] = true
+	}
+	r.failed, r.handled = nil, nil
+	return nil
+}
+
+// Discard 将 ErrorPolicy 放弃的 event 交给匹配的 Discarder 丢弃缓存
+func (r *Router) Discard(event *EventData) []*EventData {
+	r.failed, r.handled = nil, nil
+	var res []*EventData
+	for _, rt := range r.routes {
+		if d, ok := rt.handler.(Discarder); ok && rt.match(event) {
+			res = append(res, d.Discard(event)...)
+		}
+	}
+	return res
+}
+
+func (r *Router) OnAlert(msg *StatusMsg) error {
+	for _, rt := range r.routes {
+		if err := rt.handler.OnAlert(msg); err != nil {
+			return errors.Annotatef(err, "handler %s", rt.handler.String())
+		}
+	}
+	return nil
+}
+
+func (r *Router) OnClose(river *River) {
+	for _, rt := range r.routes {
+		rt.handler.OnClose(river)
+	}
+}
+
+// Committed 返回所有实现了 Committer 的 Handler 确认的位置中最小的一个
+func (r *Router) Committed(handled mysql.Position) mysql.Position {
+	res := handled
+	for _, rt := range r.routes {
+		c, ok := rt.handler.(Committer)
+		if !ok {
+			continue
+		}
+		pos := c.Committed(handled)
+		if len(pos.Name) == 0 {
+			return pos
+		}
+		if pos.Compare(res) < 0 {
+			res = pos
+		}
+	}
+	return res
+}
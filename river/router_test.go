@@ -0,0 +1,137 @@
+package river
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRouter_OnEvent(t *testing.T) {
+	got := make(map[string][]string)
+	recorder := func(name string) Handler {
+		return NopCloserAlerter(func(event *EventData) error {
+			got[name] = append(got[name], event.EventType+":"+event.Db+"."+event.Table)
+			return nil
+		})
+	}
+	router := NewRouter().
+		Route(recorder("kafka"), "orders.*").
+		Route(recorder("es"), "testdb01.user", "testdb01.role").
+		Route(recorder("trace"))
+
+	events := []*EventData{
+		{EventType: EventTypeGTID},
+		{EventType: EventTypeInsert, Db: "orders", Table: "order_item"},
+		{EventType: EventTypeUpdate, Db: "testdb01", Table: "user"},
+		{EventType: EventTypeDelete, Db: "testdb01", Table: "profile"},
+		{EventType: EventTypeDDL, Db: "orders"},
+		{EventType: EventTypeXID},
+	}
+	for _, event := range events {
+		if err := router.OnEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := map[string][]string{
+		"kafka": {"gtid:.", "insert:orders.order_item", "ddl:orders.", "xid:."},
+		"es":    {"gtid:.", "update:testdb01.user", "xid:."},
+		"trace": {"gtid:.", "insert:orders.order_item", "update:testdb01.user", "delete:testdb01.profile", "ddl:orders.", "xid:."},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestRouter_OnEventRetry(t *testing.T) {
+	var kafka, trace int
+	fail := 1
+	router := NewRouter().
+		Route(NopCloserAlerter(func(*EventData) error { kafka++; return nil })).
+		Route(NopCloserAlerter(func(*EventData) error {
+			if trace++; fail > 0 {
+				fail--
+				return fmt.Errorf("write failed")
+			}
+			return nil
+		}))
+
+	policy, err := newErrorPolicy(&ErrorPolicyConfig{MaxRetries: 1, RetryInterval: time.Millisecond}, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range []*EventData{{EventType: EventTypeInsert, Db: "db", Table: "t"}, {EventType: EventTypeXID}} {
+		if err := policy.handle(context.Background(), event, router); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 重试时跳过已经处理成功的 route
+	if kafka != 2 || trace != 3 {
+		t.Errorf("kafka handled %d events, trace handled %d events, want 2 and 3", kafka, trace)
+	}
+}
+
+func TestRouter_Discard(t *testing.T) {
+	orders, users := &bufferHandler{}, &bufferHandler{}
+	router := NewRouter().Route(orders, "orders.*").Route(users, "users.*")
+	sink := &memorySink{}
+	policy, err := newErrorPolicy(&ErrorPolicyConfig{Action: ErrorActionDeadLetter}, sink, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range []*EventData{
+		{EventType: EventTypeInsert, Db: "orders", Table: "order"},
+		{EventType: EventTypeInsert, Db: "users", Table: "user"},
+		{EventType: EventTypeXID},
+	} {
+		if err := policy.handle(context.Background(), event, router); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(orders.events) != 0 || len(users.events) != 0 || len(sink.events) != 3 {
+		t.Errorf("buffered %d and %d events, %d dead letters", len(orders.events), len(users.events), len(sink.events))
+	}
+}
+
+type committer struct {
+	NopCloserAlerter
+	pos mysql.Position
+}
+
+func (c *committer) Committed(handled mysql.Position) mysql.Position {
+	return c.pos
+}
+
+func TestRouter_Committed(t *testing.T) {
+	nop := NopCloserAlerter(func(*EventData) error { return nil })
+	handled := mysql.Position{Name: "mysql-bin.000002", Pos: 100}
+	a := &committer{NopCloserAlerter: nop, pos: mysql.Position{Name: "mysql-bin.000001", Pos: 400}}
+	b := &committer{NopCloserAlerter: nop, pos: handled}
+
+	if got := NewRouter().Route(nop).Committed(handled); got != handled {
+		t.Errorf("got %v, want %v", got, handled)
+	}
+	if got := NewRouter().Route(nop).Route(a).Route(b).Committed(handled); got != a.pos {
+		t.Errorf("got %v, want %v", got, a.pos)
+	}
+	a.pos = mysql.Position{}
+	if got := NewRouter().Route(a).Route(b).Committed(handled); len(got.Name) != 0 {
+		t.Errorf("expect empty position when a handler has nothing confirmed, got %v", got)
+	}
+}
+
+func TestCheckRoutePattern(t *testing.T) {
+	for _, pattern := range []string{"orders", "orders.*", "*.user", "db?.t[0-9]"} {
+		if err := CheckRoutePattern(pattern); err != nil {
+			t.Errorf("%s: %s", pattern, err)
+		}
+	}
+	for _, pattern := range []string{"orders.[", "[.user", "db.t\\"} {
+		if err := CheckRoutePattern(pattern); err == nil {
+			t.Errorf("%s: want error", pattern)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package river
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/juju/errors"
+	"io/ioutil"
+)
+
+// TLSConfig 证书均为PEM格式的文件路径
+type TLSConfig struct {
+	CA         string `toml:"ca"`          // 为空时使用系统根证书
+	Cert       string `toml:"cert"`        // 双向认证时的客户端证书
+	Key        string `toml:"key"`         // 双向认证时的客户端私钥
+	ServerName string `toml:"server_name"` // 为空时使用连接的host
+	SkipVerify bool   `toml:"skip_verify"` // 不校验服务端证书
+}
+
+func (c *TLSConfig) Build() (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.SkipVerify,
+	}
+	if len(c.CA) != 0 {
+		ca, err := ioutil.ReadFile(c.CA)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no valid certificate found in %s", c.CA)
+		}
+		cfg.RootCAs = pool
+	}
+	if len(c.Cert) != 0 || len(c.Key) != 0 {
+		cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,96 @@
+package river
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeKeyPair 生成自签名证书和私钥, 返回PEM文件路径
+func writeKeyPair(t *testing.T, dir string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "mysql-river"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}))
+	return certFile, keyFile
+}
+
+func writeFile(t *testing.T, file string, b []byte) {
+	if err := os.WriteFile(file, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTLSConfig_Build(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeKeyPair(t, dir)
+	badPEM := filepath.Join(dir, "bad.pem")
+	writeFile(t, badPEM, []byte("not a pem"))
+	missing := filepath.Join(dir, "missing.pem")
+
+	for _, c := range []struct {
+		name    string
+		config  *TLSConfig
+		wantErr bool
+		rootCAs bool
+		certs   int
+	}{
+		{name: "empty", config: &TLSConfig{ServerName: "db", SkipVerify: true}},
+		{name: "ca only", config: &TLSConfig{CA: certFile}, rootCAs: true},
+		{name: "client key pair", config: &TLSConfig{CA: certFile, Cert: certFile, Key: keyFile}, rootCAs: true, certs: 1},
+		{name: "missing ca", config: &TLSConfig{CA: missing}, wantErr: true},
+		{name: "missing key", config: &TLSConfig{Cert: certFile, Key: missing}, wantErr: true},
+		{name: "cert without key", config: &TLSConfig{Cert: certFile}, wantErr: true},
+		{name: "bad ca pem", config: &TLSConfig{CA: badPEM}, wantErr: true},
+		{name: "bad cert pem", config: &TLSConfig{Cert: badPEM, Key: keyFile}, wantErr: true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			cfg, err := c.config.Build()
+			if c.wantErr {
+				if err == nil {
+					t.Error("want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.ServerName != c.config.ServerName || cfg.InsecureSkipVerify != c.config.SkipVerify {
+				t.Errorf("server name %q, skip verify %v", cfg.ServerName, cfg.InsecureSkipVerify)
+			}
+			if (cfg.RootCAs != nil) != c.rootCAs {
+				t.Errorf("root cas: %v", cfg.RootCAs)
+			}
+			if len(cfg.Certificates) != c.certs {
+				t.Errorf("certificates: %d", len(cfg.Certificates))
+			}
+		})
+	}
+}